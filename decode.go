@@ -0,0 +1,353 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "bytes"
+    "io"
+    "iter"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var timeType = reflect.TypeOf(time.Time {})
+
+// A Decoder reads records from a Reader and stores their fields in structs.
+//
+// The first record that a Decoder reads is a header naming the columns of
+// the records that follow.  Decode stores each field in the exported struct
+// field whose `dsv` tag names the field's column.  Struct fields without tags
+// are matched by their names, and fields tagged `dsv:"-"` are ignored.
+//
+// Struct fields may be strings, bools, integers, floating-point numbers,
+// time.Times, or pointers to them.  Fields of any type that implements
+// FieldUnmarshaler (or whose pointer type does) are decoded by their
+// UnmarshalDSVField methods instead.
+//
+// time.Time fields are parsed with time.RFC3339 unless their tags specify a
+// layout option, as in `dsv:"date,layout=2006-01-02"`.  The layout option
+// must be the last option in a tag because the layout may contain commas.  A
+// pointer field is set to nil if the record is too short to have a field in
+// its column, so an empty field (a non-nil pointer to "") can be
+// distinguished from a missing one.
+//
+// Column names must match exactly unless HeaderNormalize is set, in which
+// case it is applied to both the header's names and the struct fields'
+// column names before they are compared.  Setting it to strings.ToLower, for
+// example, matches a "Name" column with a `dsv:"name"` field.
+//
+// Floating-point fields are parsed with strconv.ParseFloat after being
+// normalized as NumberFormat specifies.  See NumberFormat.
+type Decoder struct {
+    HeaderNormalize func(string) string    // normalizes column names before matching
+    NumberFormat    NumberFormat           // format of floating-point fields
+    reader          *Reader
+    header          []string
+}
+
+// A NumberFormat determines the decimal and digit group separators that a
+// Decoder expects in floating-point fields.
+type NumberFormat int
+
+const (
+    // NumberFormatDefault expects Go syntax, as strconv.ParseFloat does,
+    // with '.' as the decimal separator and no digit group separators.
+    NumberFormatDefault NumberFormat = iota
+
+    // NumberFormatEuropean expects ',' as the decimal separator and '.' as
+    // the digit group separator, as in "1.234,56".
+    NumberFormatEuropean
+)
+
+// normalize rewrites s, a number in format f, in Go syntax.
+func (f NumberFormat) normalize(s string) string {
+    if f == NumberFormatEuropean {
+        return strings.Replace(strings.Replace(s, ".", "", -1), ",", ".", -1)
+    }
+    return s
+}
+
+// FieldUnmarshaler is implemented by types that can parse themselves from DSV
+// fields.  UnmarshalDSVField is passed the decoded (unescaped) field.
+type FieldUnmarshaler interface {
+    UnmarshalDSVField(s string) error
+}
+
+// A DecodeError describes a field that a Decoder couldn't store in a struct.
+type DecodeError struct {
+    Record      int64     // number of the record (counting the header), starting at 1
+    Column      string    // name of the field's column
+    Field       string    // name of the struct field
+    Value       string    // the field's value
+    Err         error     // reason the value couldn't be stored
+}
+
+func (e *DecodeError) Error() string {
+    return "dsv: cannot decode " + strconv.Quote(e.Value) + " into field " +
+        e.Field + " (column " + strconv.Quote(e.Column) + ") in record " +
+        strconv.FormatInt(e.Record, 10) + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+    return e.Err
+}
+
+// NewDecoder returns a new Decoder that reads records from r.
+func NewDecoder(r *Reader) *Decoder {
+    return &Decoder {
+        reader: r,
+    }
+}
+
+// Header returns the header read by d or nil if d hasn't read it yet.
+func (d *Decoder) Header() []string {
+    return d.header
+}
+
+// Decode reads the next record from d's Reader and stores its fields in the
+// struct that v points to.  The header is read first if d hasn't read it
+// yet.  Decode returns io.EOF if no records remain and ErrNotStructPointer if
+// v isn't a non-nil pointer to a struct.
+func (d *Decoder) Decode(v interface{}) error {
+    value := reflect.ValueOf(v)
+    if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+        return ErrNotStructPointer
+    }
+    if d.header == nil {
+        header, err := d.reader.Read()
+        if err != nil {
+            return err
+        }
+        if header == nil {
+            return io.EOF
+        }
+        d.header = header
+    }
+    record, err := d.reader.Read()
+    if err != nil {
+        return err
+    }
+    if record == nil {
+        return io.EOF
+    }
+    return d.decodeRecord(record, value.Elem())
+}
+
+// normalize applies d.HeaderNormalize to column if it is set.
+func (d *Decoder) normalize(column string) string {
+    if d.HeaderNormalize == nil {
+        return column
+    }
+    return d.HeaderNormalize(column)
+}
+
+// UnmarshalAll reads every record from r using the settings in d and stores
+// them in the slice of structs that v points to, replacing its contents.  As
+// with Decoder, the first record is a header naming the columns.
+// UnmarshalAll returns ErrNotSlicePointer if v isn't a non-nil pointer to a
+// slice of structs, and it returns the first error encountered (such as a
+// *DecodeError identifying the record and field) without modifying the slice.
+// If r is a *bytes.Buffer, the slice is allocated for the number of records
+// in r in advance.
+func UnmarshalAll(r io.Reader, v interface{}, d Dialect) error {
+    value := reflect.ValueOf(v)
+    if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Slice ||
+            value.Elem().Type().Elem().Kind() != reflect.Struct {
+        return ErrNotSlicePointer
+    }
+    var records int
+    if buffer, ok := r.(*bytes.Buffer); ok {
+        records = bytes.Count(buffer.Bytes(), []byte(string(d.RecordSeparator)))
+    }
+    reader := NewReader(runeReader(r))
+    reader.SetDialect(d)
+    decoder := NewDecoder(reader)
+    slice := reflect.MakeSlice(value.Elem().Type(), 0, records)
+    for {
+        element := reflect.New(slice.Type().Elem())
+        err := decoder.Decode(element.Interface())
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        slice = reflect.Append(slice, element.Elem())
+    }
+    value.Elem().Set(slice)
+    return nil
+}
+
+// All returns an iterator over the records remaining in d's Reader, each
+// decoded into a new T, which must be a struct type, as by Decode.  The
+// iterator stops at the end of the input or after yielding the first error
+// together with a zero T.  Records are decoded one at a time as the caller
+// ranges over the iterator, so the input is never held in memory at once.
+func All[T any](d *Decoder) iter.Seq2[T, error] {
+    return func(yield func(T, error) bool) {
+        for {
+            var record T
+            err := d.Decode(&record)
+            if err == io.EOF {
+                return
+            }
+            if err != nil {
+                var zero T
+                yield(zero, err)
+                return
+            }
+            if !yield(record, nil) {
+                return
+            }
+        }
+    }
+}
+
+// decodeRecord stores the fields of record in the struct s.
+func (d *Decoder) decodeRecord(record []string, s reflect.Value) error {
+    columns := make(map[string]int, len(d.header))
+    for n, column := range d.header {
+        column = d.normalize(column)
+        if _, ok := columns[column]; !ok {
+            columns[column] = n
+        }
+    }
+    for _, field := range structFields(s.Type()) {
+        n, ok := columns[d.normalize(field.column)]
+        if !ok {
+            continue
+        }
+        target := s.Field(field.index)
+        if n >= len(record) {
+            if target.Kind() == reflect.Ptr {
+                target.Set(reflect.Zero(target.Type()))
+            }
+            continue
+        }
+        if err := decodeField(target, record[n], field, d.NumberFormat); err != nil {
+            return &DecodeError {
+                Record: d.reader.records,
+                Column: field.column,
+                Field:  field.name,
+                Value:  record[n],
+                Err:    err,
+            }
+        }
+    }
+    return nil
+}
+
+// decodeField parses s and stores the result in target, which is the value
+// of field.  Floating-point numbers are parsed in the given format.
+func decodeField(target reflect.Value, s string, field structField, format NumberFormat) error {
+    if target.CanAddr() {
+        if u, ok := target.Addr().Interface().(FieldUnmarshaler); ok {
+            return u.UnmarshalDSVField(s)
+        }
+    }
+    if target.Kind() == reflect.Ptr {
+        value := reflect.New(target.Type().Elem())
+        if err := decodeField(value.Elem(), s, field, format); err != nil {
+            return err
+        }
+        target.Set(value)
+        return nil
+    }
+    if target.Type() == timeType {
+        t, err := time.Parse(field.layout, s)
+        if err != nil {
+            return err
+        }
+        target.Set(reflect.ValueOf(t))
+        return nil
+    }
+    switch target.Kind() {
+        case reflect.String:
+            target.SetString(s)
+        case reflect.Bool:
+            b, err := strconv.ParseBool(s)
+            if err != nil {
+                return err
+            }
+            target.SetBool(b)
+        case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+            i, err := strconv.ParseInt(s, 10, target.Type().Bits())
+            if err != nil {
+                return err
+            }
+            target.SetInt(i)
+        case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+            u, err := strconv.ParseUint(s, 10, target.Type().Bits())
+            if err != nil {
+                return err
+            }
+            target.SetUint(u)
+        case reflect.Float32, reflect.Float64:
+            f, err := strconv.ParseFloat(format.normalize(s), target.Type().Bits())
+            if err != nil {
+                return err
+            }
+            target.SetFloat(f)
+        default:
+            return ErrUnsupportedType
+    }
+    return nil
+}
+
+// A structField describes a struct field that is mapped to a DSV column.
+type structField struct {
+    index       int       // index of the field within its struct
+    name        string    // name of the field
+    column      string    // name of the field's column
+    layout      string    // layout of time.Time fields
+}
+
+// structFields returns the exported fields of struct type t that are mapped
+// to DSV columns, in the order in which they are declared.
+func structFields(t reflect.Type) (fields []structField) {
+    for n := 0; n < t.NumField(); n++ {
+        f := t.Field(n)
+        if f.PkgPath != "" {
+            continue
+        }
+        tag := f.Tag.Get("dsv")
+        if tag == "-" {
+            continue
+        }
+        field := structField {
+            index:  n,
+            name:   f.Name,
+            column: tag,
+            layout: time.RFC3339,
+        }
+        if i := strings.IndexByte(tag, ','); i >= 0 {
+            field.column = tag[:i]
+            for options := tag[i + 1:]; options != ""; {
+                if strings.HasPrefix(options, "layout=") {
+                    field.layout = options[len("layout="):]
+                    break
+                }
+                if i = strings.IndexByte(options, ','); i < 0 {
+                    break
+                }
+                options = options[i + 1:]
+            }
+        }
+        if field.column == "" {
+            field.column = f.Name
+        }
+        fields = append(fields, field)
+    }
+    return
+}
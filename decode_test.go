@@ -0,0 +1,256 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "strings"
+    "testing"
+    "time"
+)
+
+type nullableRecord struct {
+    Name        string  `dsv:"name"`
+    Nickname    *string `dsv:"nickname"`
+    Age         *int    `dsv:"age"`
+    Ignored     string  `dsv:"-"`
+}
+
+func TestDecodeNullableFields(t *testing.T) {
+    input := "name:nickname:age\nAda::36\nGrace\n"
+    decoder := NewDecoder(NewReader(strings.NewReader(input)))
+
+    var record nullableRecord
+    if err := decoder.Decode(&record); err != nil {
+        t.Fatal(fmt.Sprintf("error while decoding valid DSV record: %v", err))
+    }
+    if record.Name != "Ada" {
+        t.Fatal(fmt.Sprintf("decoded name %q isn't \"Ada\"", record.Name))
+    }
+    if record.Nickname == nil || *record.Nickname != "" {
+        t.Fatal("empty nickname field didn't decode to a non-nil empty string")
+    }
+    if record.Age == nil || *record.Age != 36 {
+        t.Fatal("age field didn't decode to 36")
+    }
+
+    record = nullableRecord {}
+    if err := decoder.Decode(&record); err != nil {
+        t.Fatal(fmt.Sprintf("error while decoding short DSV record: %v", err))
+    }
+    if record.Name != "Grace" {
+        t.Fatal(fmt.Sprintf("decoded name %q isn't \"Grace\"", record.Name))
+    }
+    if record.Nickname != nil {
+        t.Fatal(fmt.Sprintf("missing nickname field decoded to %q instead of nil", *record.Nickname))
+    }
+    if record.Age != nil {
+        t.Fatal("missing age field didn't decode to nil")
+    }
+
+    if err := decoder.Decode(&record); err != io.EOF {
+        t.Fatal(fmt.Sprintf("Decode returned %v instead of io.EOF after the last record", err))
+    }
+}
+
+func TestDecodeErrors(t *testing.T) {
+    decoder := NewDecoder(NewReader(strings.NewReader("name:age\nAda:old\n")))
+    var record nullableRecord
+    err := decoder.Decode(&record)
+    decodeErr, ok := err.(*DecodeError)
+    if !ok {
+        t.Fatal(fmt.Sprintf("Decode returned %v instead of a DecodeError", err))
+    }
+    if decodeErr.Field != "Age" || decodeErr.Column != "age" || decodeErr.Value != "old" {
+        t.Fatal(fmt.Sprintf("DecodeError %+v doesn't describe the age field", decodeErr))
+    }
+    if err = decoder.Decode(record); err != ErrNotStructPointer {
+        t.Fatal(fmt.Sprintf("Decode returned %v instead of ErrNotStructPointer", err))
+    }
+}
+
+type clockTime time.Duration
+
+func (c *clockTime) UnmarshalDSVField(s string) error {
+    var hours, minutes int
+    if _, err := fmt.Sscanf(s, "%d:%d", &hours, &minutes); err != nil {
+        return err
+    }
+    *c = clockTime(time.Duration(hours) * time.Hour + time.Duration(minutes) * time.Minute)
+    return nil
+}
+
+func TestDecodeFieldUnmarshaler(t *testing.T) {
+    input := "event:start:end\nstandup:09\\:30:09\\:45\nlunch:12\\:00\n"
+    decoder := NewDecoder(NewReader(strings.NewReader(input)))
+    var record struct {
+        Event   string      `dsv:"event"`
+        Start   clockTime   `dsv:"start"`
+        End     *clockTime  `dsv:"end"`
+    }
+    if err := decoder.Decode(&record); err != nil {
+        t.Fatal(fmt.Sprintf("error while decoding valid DSV record: %v", err))
+    }
+    if time.Duration(record.Start) != 9 * time.Hour + 30 * time.Minute {
+        t.Fatal(fmt.Sprintf("start field decoded to %v instead of 9h30m", time.Duration(record.Start)))
+    }
+    if record.End == nil || time.Duration(*record.End) != 9 * time.Hour + 45 * time.Minute {
+        t.Fatal("end field didn't decode to 9h45m")
+    }
+
+    if err := decoder.Decode(&record); err != nil {
+        t.Fatal(fmt.Sprintf("error while decoding short DSV record: %v", err))
+    }
+    if time.Duration(record.Start) != 12 * time.Hour || record.End != nil {
+        t.Fatal("short record didn't decode to a 12h start and a nil end")
+    }
+
+    decoder = NewDecoder(NewReader(strings.NewReader("start\nnoon\n")))
+    if _, ok := decoder.Decode(&record).(*DecodeError); !ok {
+        t.Fatal("UnmarshalDSVField error wasn't reported as a DecodeError")
+    }
+}
+
+func TestDecodeTime(t *testing.T) {
+    input := "created:due\n2015-04-29T10\\:30\\:00Z:2015-05-01\n"
+    decoder := NewDecoder(NewReader(strings.NewReader(input)))
+    var record struct {
+        Created time.Time   `dsv:"created"`
+        Due     *time.Time  `dsv:"due,layout=2006-01-02"`
+    }
+    if err := decoder.Decode(&record); err != nil {
+        t.Fatal(fmt.Sprintf("error while decoding valid DSV record: %v", err))
+    }
+    if !record.Created.Equal(time.Date(2015, 4, 29, 10, 30, 0, 0, time.UTC)) {
+        t.Fatal(fmt.Sprintf("created field decoded to %v", record.Created))
+    }
+    if record.Due == nil || !record.Due.Equal(time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC)) {
+        t.Fatal("due field didn't decode with its custom layout")
+    }
+
+    decoder = NewDecoder(NewReader(strings.NewReader("due\n05/01/2015\n")))
+    err := decoder.Decode(&record)
+    decodeErr, ok := err.(*DecodeError)
+    if !ok || decodeErr.Field != "Due" || decodeErr.Value != "05/01/2015" {
+        t.Fatal(fmt.Sprintf("Decode returned %v instead of a DecodeError for the due field", err))
+    }
+    if !strings.Contains(err.Error(), "Due") || !strings.Contains(err.Error(), "05/01/2015") {
+        t.Fatal(fmt.Sprintf("error %q doesn't name the field and its value", err))
+    }
+}
+
+func TestDecodeHeaderNormalize(t *testing.T) {
+    input := "Name:NICKNAME:Age\nAda:Countess:36\n"
+    decoder := NewDecoder(NewReader(strings.NewReader(input)))
+    decoder.HeaderNormalize = strings.ToLower
+
+    var record nullableRecord
+    if err := decoder.Decode(&record); err != nil {
+        t.Fatal(fmt.Sprintf("error while decoding valid DSV record: %v", err))
+    }
+    if record.Name != "Ada" || record.Nickname == nil || *record.Nickname != "Countess" ||
+            record.Age == nil || *record.Age != 36 {
+        t.Fatal(fmt.Sprintf("decoded record %+v doesn't match the input", record))
+    }
+
+    decoder = NewDecoder(NewReader(strings.NewReader(input)))
+    record = nullableRecord {}
+    if err := decoder.Decode(&record); err != nil {
+        t.Fatal(fmt.Sprintf("error while decoding valid DSV record: %v", err))
+    }
+    if record.Name != "" {
+        t.Fatal(fmt.Sprintf("decoded name %q despite the header's different case", record.Name))
+    }
+}
+
+func TestUnmarshalAll(t *testing.T) {
+    dialect := Dialect {Escape: '\\', Separator: ':', RecordSeparator: '\n'}
+    input := "name:nickname:age:unknown\nAda:Countess:36:x\nGrace::85\nAlan\n"
+    var records []nullableRecord
+    if err := UnmarshalAll(bytes.NewBufferString(input), &records, dialect); err != nil {
+        t.Fatal(fmt.Sprintf("error while unmarshaling valid DSV: %v", err))
+    }
+    if len(records) != 3 || cap(records) != 4 {
+        t.Fatal(fmt.Sprintf("UnmarshalAll decoded %v records with capacity %v", len(records), cap(records)))
+    }
+    if records[0].Name != "Ada" || *records[0].Nickname != "Countess" || *records[0].Age != 36 ||
+            records[1].Name != "Grace" || *records[1].Nickname != "" || *records[1].Age != 85 ||
+            records[2].Name != "Alan" || records[2].Nickname != nil || records[2].Age != nil {
+        t.Fatal(fmt.Sprintf("decoded records %+v don't match the input", records))
+    }
+
+    err := UnmarshalAll(strings.NewReader("name:age\nAda:36\nGrace:old\n"), &records, dialect)
+    decodeErr, ok := err.(*DecodeError)
+    if !ok || decodeErr.Record != 3 || decodeErr.Field != "Age" {
+        t.Fatal(fmt.Sprintf("UnmarshalAll returned %v instead of a DecodeError for record 3", err))
+    }
+    if len(records) != 3 || records[0].Name != "Ada" || records[2].Name != "Alan" {
+        t.Fatal("UnmarshalAll modified the slice despite an error")
+    }
+    if err = UnmarshalAll(strings.NewReader(input), records, dialect); err != ErrNotSlicePointer {
+        t.Fatal(fmt.Sprintf("UnmarshalAll returned %v instead of ErrNotSlicePointer", err))
+    }
+}
+
+func TestAll(t *testing.T) {
+    reader := NewReader(strings.NewReader("name:age\nAda:36\nGrace:85\nAlan:old\nEdsger:72\n"))
+    var names []string
+    var lastErr error
+    for record, err := range All[nullableRecord](NewDecoder(reader)) {
+        if err != nil {
+            if record.Name != "" || record.Age != nil {
+                t.Fatal(fmt.Sprintf("All yielded %+v with error %v", record, err))
+            }
+            lastErr = err
+            continue
+        }
+        names = append(names, record.Name)
+    }
+    if fmt.Sprintf("%q", names) != fmt.Sprintf("%q", []string {"Ada", "Grace"}) {
+        t.Fatal(fmt.Sprintf("All yielded records named %q", names))
+    }
+    if decodeErr, ok := lastErr.(*DecodeError); !ok || decodeErr.Record != 4 {
+        t.Fatal(fmt.Sprintf("All yielded %v instead of a DecodeError for record 4", lastErr))
+    }
+
+    reader = NewReader(strings.NewReader("name\nAda\nGrace\n"))
+    for record := range All[nullableRecord](NewDecoder(reader)) {
+        if record.Name != "Ada" {
+            t.Fatal(fmt.Sprintf("All yielded %+v first", record))
+        }
+        break
+    }
+    if record, err := reader.Read(); err != nil || fmt.Sprintf("%q", record) != `["Grace"]` {
+        t.Fatal(fmt.Sprintf("All read past the record at which iteration stopped: %q, %v", record, err))
+    }
+}
+
+func TestNumberFormat(t *testing.T) {
+    var record struct {
+        Price   float64    `dsv:"price"`
+        Weight  *float32   `dsv:"weight"`
+    }
+    decoder := NewDecoder(NewReader(strings.NewReader("price:weight\n1.234,56:0,5\n")))
+    decoder.NumberFormat = NumberFormatEuropean
+    if err := decoder.Decode(&record); err != nil {
+        t.Fatal(err)
+    }
+    if record.Price != 1234.56 || record.Weight == nil || *record.Weight != 0.5 {
+        t.Fatal(fmt.Sprintf("European number format decoded %+v", record))
+    }
+    decoder = NewDecoder(NewReader(strings.NewReader("price:weight\n1.234,56:0,5\n")))
+    if err := decoder.Decode(&record); err == nil {
+        t.Fatal("default number format decoded \"1.234,56\"")
+    }
+}
@@ -22,188 +22,3279 @@
 // it with a single reverse solidus ('\\').  Whitespace is preserved within
 // fields.  The final record may be optionally followed by one or more
 // newline characters.
+//
+// The escape, field separator, and record separator characters are
+// configurable.  The record separator must be a valid, nonzero rune that
+// differs from the escape and field separator characters; Readers and Writers
+// configured otherwise return ErrEmptyRecordSeparator or
+// ErrRecordSeparatorConflict instead of reading or writing anything.
 package dsv
 
 import (
     "bufio"
     "bytes"
+    "compress/gzip"
+    "context"
+    "encoding/base64"
+    "errors"
     "io"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+)
+
+// Errors returned by the package's functions and by the methods of Readers
+// and Writers.
+var (
+    ErrEmptyRecordSeparator    = errors.New("dsv: empty or invalid record separator")
+    ErrRecordSeparatorConflict = errors.New("dsv: record separator equals escape or field separator")
+    ErrSeparatorConflict       = errors.New("dsv: field separator equals escape character")
+    ErrMultipleRecords         = errors.New("dsv: more than one record")
+    ErrNotSeekable             = errors.New("dsv: source is not an io.Seeker")
+    ErrTooManyRecords          = errors.New("dsv: too many records")
+    ErrNotStructPointer        = errors.New("dsv: value is not a non-nil pointer to a struct")
+    ErrNotStruct               = errors.New("dsv: value is not a struct or a non-nil pointer to a struct")
+    ErrUnsupportedType         = errors.New("dsv: unsupported struct field type")
+    ErrNotSlicePointer         = errors.New("dsv: value is not a non-nil pointer to a slice of structs")
+    ErrTooManyFields           = errors.New("dsv: record has more fields than the split limit")
+    ErrNotByteReader           = errors.New("dsv: source is not an io.ByteReader")
+    ErrVerbatimField           = errors.New("dsv: verbatim field contains the record separator")
+    ErrNoEscape                = errors.New("dsv: field needs escaping but there is no escape character")
+    ErrUnsupportedFraming      = errors.New("dsv: operation not supported with LengthPrefixed or Split")
+    ErrColumnIndex             = errors.New("dsv: column order index out of range")
+    ErrNoHeader                = errors.New("dsv: no header has been read")
+    ErrNoSchemaHeader          = errors.New("dsv: input doesn't begin with a schema header")
+    ErrSchemaHeader            = errors.New("dsv: malformed schema header")
+    ErrColumnLength            = errors.New("dsv: columns have unequal lengths")
+    ErrRecordNumber            = errors.New("dsv: no record with the requested number")
+)
+
+// Errors reported by Readers within ParseErrors (and, for ErrNUL and
+// ErrRawField, by Writers within WriteErrors).  Writers also report
+// ErrTooManyFields, ErrVerbatimField, and ErrNoEscape within WriteErrors.
+var (
+    ErrInvalidUTF8        = errors.New("invalid UTF-8")
+    ErrInvalidFieldLength = errors.New("invalid field length")
+    ErrUnterminatedQuote  = errors.New("unterminated quoted field")
+    ErrFieldTooLarge      = errors.New("field too large")
+    ErrInvalidUnicode     = errors.New("invalid Unicode escape sequence")
+    ErrFieldCount         = errors.New("wrong number of fields")
+    ErrDanglingEscape     = errors.New("escape character at end of input")
+    ErrNUL                = errors.New("field contains NUL byte")
+    ErrRawField           = errors.New("raw field contains a separator")
+)
+
+// A ParseError describes a problem with a Reader's input.
+type ParseError struct {
+    Record      int64     // number of the record being read, starting at 1
+    Field       int       // number of the field being read, starting at 1
+    Offset      int64     // byte offset of the problem
+    Err         error     // the problem
+    Context     string    // input around Offset if the Reader's DebugContext is set
+}
+
+func (e *ParseError) Error() string {
+    s := "dsv: parse error in record " + strconv.FormatInt(e.Record, 10) +
+        " field " + strconv.Itoa(e.Field) + " at byte offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Err.Error()
+    if e.Context != "" {
+        s += " near " + strconv.Quote(e.Context)
+    }
+    return s
+}
+
+func (e *ParseError) Unwrap() error {
+    return e.Err
+}
+
+// A WriteError describes a record that a Writer refused to write.
+type WriteError struct {
+    Record      int64    // number of the record passed to the Writer, starting at 1
+    Field       int      // number of the offending field, starting at 1
+    Err         error    // the problem
+}
+
+func (e *WriteError) Error() string {
+    return "dsv: cannot write record " + strconv.FormatInt(e.Record, 10) +
+        " field " + strconv.Itoa(e.Field) + ": " + strings.TrimPrefix(e.Err.Error(), "dsv: ")
+}
+
+func (e *WriteError) Unwrap() error {
+    return e.Err
+}
+
+// An InvalidUTF8Mode determines how a Reader handles input that isn't valid
+// UTF-8.
+type InvalidUTF8Mode int
+
+const (
+    // InvalidUTF8Replace replaces each invalid byte with utf8.RuneError.
+    InvalidUTF8Replace InvalidUTF8Mode = iota
+
+    // InvalidUTF8Error makes Read return a *ParseError wrapping
+    // ErrInvalidUTF8 at the first invalid byte.
+    InvalidUTF8Error
+
+    // InvalidUTF8Passthrough keeps invalid bytes in fields as is.  The
+    // Reader's source must implement io.RuneScanner and io.ByteReader;
+    // otherwise invalid bytes are replaced.
+    InvalidUTF8Passthrough
 )
 
 // A Reader reads records from a DSV file.
 //
-// Readers returned by NewReader use reverse solidus characters ('\\') and
-// colon characters (':') as escape and record separator characters,
-// respectively.  The Reader's exported fields can be modified to change
-// these settings.
+// Readers returned by NewReader use reverse solidus characters ('\\'),
+// colon characters (':'), and newline characters ('\n') as escape, field
+// separator, and record separator characters, respectively.  The Reader's
+// exported fields can be modified to change these settings.
+//
+// Some dialects escape characters with a prefix of several runes.  If
+// EscapeString isn't empty, it is the escape prefix and Escape is ignored.
+// An occurrence of the prefix's first rune that doesn't begin the whole
+// prefix is an ordinary character.  If Escape is zero and EscapeString is
+// empty, nothing is escaped, as in CSV, where quoting alone protects
+// separators (see Quote).
+//
+// Readers returned by NewReader also skip a byte order mark (U+FEFF) if it is
+// the first rune of their input.  Clear SkipBOM before the first call to Read
+// to keep it as part of the first field.
+//
+// Some producers terminate every field, including the last, with a
+// separator, so that "a:b:" means two fields rather than three.  Setting
+// TrimTrailingEmptyFields drops the empty field that follows a record's
+// final separator, and setting TrimAllTrailingEmptyFields drops all empty
+// fields at the end of a record.  Records always keep at least one field.
+//
+// Setting DebugContext makes the ParseErrors that a Reader returns include
+// the input around the problem (up to 20 bytes on either side), which helps
+// locate malformed data in large files.
+//
+// A Reader collects each field in a buffer that grows as needed and keeps
+// its capacity between fields.  Setting InitialFieldCap before the first call
+// to Read sizes the buffer in advance, which avoids repeated growth when
+// fields are known to be large.
+//
+// If SplitLimit is positive, records have at most SplitLimit fields, as with
+// strings.SplitN: once SplitLimit-1 separators have been read, further
+// unescaped separators are part of the last field.  This suits key/value data
+// whose values may contain separators.  If VerbatimLastField is also set,
+// escape characters in the SplitLimit-th field are ordinary characters too,
+// so that field extends verbatim to the record separator, as written by
+// Writers whose VerbatimField method was called with SplitLimit-1.
+//
+// Setting CollectStats makes a Reader count the records, runes, and bytes it
+// reads, both in total and for the most recent record (including any blank
+// lines before it), for inspecting parsing overhead with Stats.
+//
+// Setting LengthPrefixed selects an alternative framing that needs no
+// escaping and preserves field content byte for byte: each field is its
+// length in bytes as a decimal number, a Separator, and then the field's
+// content, and each record's fields are followed by a RecordSeparator
+// ("3:abc4:defg\n" holds the fields "abc" and "defg").  Escape characters
+// have no special meaning in this mode, neither Separator nor
+// RecordSeparator may be a decimal digit, and the source must implement
+// io.ByteReader (Read returns ErrNotByteReader otherwise).
+//
+// Setting Split before the first call to Read replaces the built-in record
+// framing with a bufio.SplitFunc: each token it returns is a record, which is
+// then split into fields as usual, with record separators treated as ordinary
+// characters.  Empty tokens count as blank lines.  Readers using Split scan
+// their source with a bufio.Scanner, so records are limited to
+// bufio.MaxScanTokenSize bytes, SeekRecord isn't supported, and RecordOffset
+// is the offset at which the split function began the record.
+//
+// If Quote is nonzero, a field that begins with a Quote character extends to
+// the next Quote character not doubled, as in CSV: separators, record
+// separators, and escape characters are ordinary characters within it, and
+// doubled Quote characters stand for one.  Any characters after the closing
+// quote are part of the field, and Quote characters elsewhere are ordinary.
+// An unterminated quoted field extends to the end of the input unless
+// MaxQuotedFieldBytes is positive, in which case a quoted field longer than
+// MaxQuotedFieldBytes bytes makes the Reader treat the opening quote as an
+// ordinary character, parse the rest of the field again, and report a
+// *ParseError wrapping ErrUnterminatedQuote to OnWarning (if it is set).
+// Quotes are not interpreted with LengthPrefixed or Split.
+//
+// If MaxFieldBytes is positive, Read returns a *ParseError wrapping
+// ErrFieldTooLarge, located at the start of the field, as soon as a field
+// exceeds MaxFieldBytes bytes.  The error's Field identifies the field.
+//
+// If FieldsPerRecord is positive, Read returns each record that doesn't have
+// exactly FieldsPerRecord fields together with a *ParseError wrapping
+// ErrFieldCount, located at the start of the record.  An escape character at
+// the very end of the input escapes nothing; Read reports it to OnWarning (if
+// it is set) as a *ParseError wrapping ErrDanglingEscape.  Lint collects
+// these and all other problems in an input.
+//
+// Read skips blank lines (empty records) between records.  If
+// KeepLeadingBlanks is set, each blank line at the start of the input is
+// instead returned as a record with a single empty field, for formats in
+// which leading blank lines are meaningful.  If PreserveEmptyRecords is set,
+// every blank line is returned that way, wherever it appears, so that the
+// empty records that Writers write for records with no fields or a single
+// empty field are read back.  If OnBlankLines is set, Read
+// calls it with the number of record separators it skipped (see
+// LeadingBlankLines) whenever it skips any, so that rewriting tools can
+// reproduce the spacing between records.
+//
+// If UnicodeEscapes is set, an escape character followed by 'u' and four
+// hexadecimal digits or by 'U' and eight hexadecimal digits stands for the
+// rune with that code point, as written by Writers with EscapeNonASCII set.
+// Read returns a *ParseError wrapping ErrInvalidUnicode for malformed
+// sequences.
+//
+// If Base64Fields is set, Read decodes each field whose index (counting from
+// zero) it reports true for from standard base64, as written by Writers with
+// the same Base64Fields, so that binary data crosses text-only channels
+// without escaping.  Read returns a *ParseError wrapping the decoding error
+// for a field that isn't valid base64.
+//
+// If CollapseInnerWhitespace is set, Read replaces each run of unescaped
+// whitespace characters (as defined by unicode.IsSpace) within a field with a
+// single space, normalizing fields such as "a \t b" to "a b".  Escaped and
+// quoted whitespace is kept as is, and runs at the start or end of a field
+// become a single space too.  The setting has no effect with LengthPrefixed.
+//
+// If TrimLeadingSpace or TrimTrailingSpace is set, Read removes unescaped,
+// unquoted whitespace from the start or end of each field.  Whitespace
+// consists of the characters in TrimCutset or, if it is empty, of those that
+// unicode.IsSpace reports, so a TrimCutset of " " trims spaces but keeps
+// tabs.  The settings have no effect with LengthPrefixed or Split.
+//
+// If RawFieldMarker is nonzero, a field that begins with it is raw: Read
+// drops the marker and returns the rest of the field verbatim, without
+// processing escapes, quotes, or whitespace, up to the next separator or
+// record separator.  Raw fields therefore hold opaque text such as blobs
+// containing escape characters, but no separators.  A marker that is escaped
+// or that doesn't begin a field is an ordinary character.  The setting has no
+// effect with LengthPrefixed or Split.
+//
+// If RejectNUL is set, Read returns each record that has a field containing a
+// NUL byte (after any base64 decoding) together with a *ParseError wrapping
+// ErrNUL, located at the start of the record, whose Field identifies the
+// field.  This protects consumers that treat NUL as a terminator.
+//
+// If Comment is nonzero, Read skips every line that begins with it where a
+// record could begin.  Comment characters elsewhere, including escaped ones
+// at the start of a line, are ordinary characters.  The setting has no effect
+// with LengthPrefixed or Split.
+//
+// If RecordContinuation is set, Read calls it with the last field of each
+// record it reads, and if it returns true, Read appends the fields of the
+// following record to the record and repeats, so that one logical record can
+// span several physical ones.  Continued fields, including the one sent to
+// RecordContinuation, are returned as is, so markers that they contain
+// (such as a trailing '+') remain in the fields.  RecordOffset,
+// PhysicalLines, RawFields, and ParseErrors' record numbers describe the whole
+// logical record, and settings such as FieldsPerRecord apply to it rather
+// than to its parts.  RawRecord returns the raw text of the physical records
+// joined by record separators, without any blank or comment lines between
+// them.
 type Reader struct {
-    Escape      rune    // prefix for escaping characters
-    Separator   rune    // field delimiter/separator
-    reader      io.RuneReader
-    field       bytes.Buffer
+    Escape                     rune                    // prefix for escaping characters
+    EscapeString               string                  // multi-rune escape prefix overriding Escape
+    Separator                  rune                    // field delimiter/separator
+    RecordSeparator            rune                    // record delimiter/separator
+    SkipBOM                    bool                    // skip a byte order mark at the start of input
+    KeepRaw                    bool                    // retain each record's raw bytes for RawRecord
+    KeepRawFields              bool                    // retain each field's raw text for RawFields
+    TrimTrailingEmptyFields    bool                    // drop a trailing empty field
+    TrimAllTrailingEmptyFields bool                    // drop all trailing empty fields
+    MaxRecords                 int                     // ReadAll's record limit (0 for none)
+    OnInvalidUTF8              InvalidUTF8Mode         // handling of invalid UTF-8 input
+    DebugContext               bool                    // include nearby input in ParseErrors
+    InitialFieldCap            int                     // initial capacity of the field buffer in bytes
+    SplitLimit                 int                     // maximum number of fields per record (0 for none)
+    CollectStats               bool                    // track parsing statistics for Stats
+    LengthPrefixed             bool                    // read length-prefixed fields instead of escaped ones
+    Split                      bufio.SplitFunc         // custom record framing (nil for RecordSeparator)
+    VerbatimLastField          bool                    // read the SplitLimit-th field without unescaping
+    Quote                      rune                    // encloses quoted fields (0 for none)
+    MaxQuotedFieldBytes        int                     // limit before an opening quote is literal (0 for none)
+    OnWarning                  func(error)             // called for recoverable problems
+    MaxFieldBytes              int                     // maximum field length in bytes (0 for none)
+    KeepLeadingBlanks          bool                    // return blank lines before the first record
+    PreserveEmptyRecords       bool                    // return every blank line as a record
+    UnicodeEscapes             bool                    // decode \uNNNN and \UNNNNNNNN escape sequences
+    OnBlankLines               func(count int)         // called with the number of blank lines skipped
+    FieldsPerRecord            int                     // required number of fields per record (0 for any)
+    PadShortRecords            bool                    // pad records too short for the column order or header
+    Base64Fields               func(index int) bool    // reports fields to decode from base64
+    CollapseInnerWhitespace    bool                    // replace unescaped whitespace runs with a space
+    RejectNUL                  bool                    // reject fields containing NUL bytes
+    GenerateColumnNames        bool                    // name columns "col0", "col1", ... without a header
+    HeaderNormalize            func(string) string     // normalizes header names for ReadMap
+    ResolveEscape              EscapeResolver          // resolves escape sequences if non-nil
+    Comment                    rune                    // begins comment lines (0 for none)
+    TrimLeadingSpace           bool                    // trim unescaped whitespace from field starts
+    TrimTrailingSpace          bool                    // trim unescaped whitespace from field ends
+    TrimCutset                 string                  // whitespace to trim (empty for unicode.IsSpace)
+    RawFieldMarker             rune                    // begins fields read verbatim (0 for none)
+    RecordContinuation         func(string) bool       // reports whether a record continues
+    reader                     io.RuneReader
+    closer                     io.Closer
+    seeker                     io.ReadSeeker
+    field                      bytes.Buffer
+    offset                     int64
+    recordOffset               int64
+    records                    int64
+    blankLines                 int
+    started                    bool
+    raw                        bytes.Buffer
+    invalid                    bool
+    invalidByte                byte
+    passthrough                bool
+    pending                    []pendingRune
+    context                    []byte
+    stats                      ReaderStats
+    fieldNumber                int
+    seenRecord                 bool
+    physicalLines              int
+    scanner                    *bufio.Scanner
+    inRecord                   bool
+    fieldOffset                int64
+    columnOrder                []int
+    rawFields                  []string
+    fieldLimit                 int
+    header                     []string
+    midRecord                  bool
+}
+
+// An EscapeResolver defines custom escape semantics for a Reader.  The Reader
+// calls it with the rune next following an unescaped escape character.  If
+// isSeparator is true, the escape sequence ends the field as an unescaped
+// separator would; otherwise, the Reader appends emit to the field unless emit
+// is negative.  If consume is false, next isn't part of the escape sequence,
+// and the Reader reads it again as an unescaped rune.  The default, nil
+// resolver behaves like one that returns next, false, and true.  A non-nil
+// resolver takes precedence over UnicodeEscapes.
+type EscapeResolver func(next rune) (emit rune, isSeparator bool, consume bool)
+
+// A ReaderStats holds the parsing statistics that a Reader collects when its
+// CollectStats field is set.
+type ReaderStats struct {
+    Records     int64    // records read
+    Runes       int64    // runes read from the source
+    Bytes       int64    // bytes read from the source
+    RecordRunes int64    // runes read for the most recent record
+    RecordBytes int64    // bytes read for the most recent record
+}
+
+// A pendingRune is a rune (or error) that a Reader read from its source and
+// then pushed back to read again.
+type pendingRune struct {
+    c           rune
+    size        int
+    invalid     bool
+    invalidByte byte
+    err         error
 }
 
 // A Writer writes records to an io.Writer in DSV format.
 //
-// Writers returned by NewWriter use reverse solidus characters ('\\') and
-// colon characters (':') as escape and record separator characters,
-// respectively.  The Writer's exported fields can be modified to change
-// these settings.
+// Writers returned by NewWriter use reverse solidus characters ('\\'),
+// colon characters (':'), and newline characters ('\n') as escape, field
+// separator, and record separator characters, respectively.  The Writer's
+// exported fields can be modified to change these settings.
+//
+// If EscapeString isn't empty, it is the escape prefix and Escape is ignored.
+// Writers escape every occurrence of the prefix's first rune so that Readers
+// can't mistake it (or a rune before it) for part of an escape prefix.
+//
+// A Writer whose Escape is zero and whose EscapeString is empty has no escape
+// character.  If its Quote is nonzero, it encloses each field that would
+// otherwise need escaping in Quote characters, as CSV writers do.
+// Otherwise, Write rejects such fields with a *WriteError wrapping
+// ErrNoEscape before anything is written.
+//
+// If AlwaysQuote is set and Quote is nonzero, every field (including empty
+// fields) is enclosed in Quote characters, as in strict CSV output.  Quote
+// characters within such fields are doubled and no other characters are
+// escaped.  Readers interpret quotes only if their Quote field is set.
+//
+// If QuoteEmptyFields is set and Quote is nonzero, empty fields are written
+// as two Quote characters and records with no fields aren't written at all,
+// so that a record with a single empty field reads back as one even though
+// Readers skip blank lines.  The setting has no effect with LengthPrefixed.
+//
+// If SplitLimit is positive, Writers produce data for Readers with the same
+// SplitLimit: separators in a record's SplitLimit-th field are written
+// unescaped, and records with more than SplitLimit fields are rejected with a
+// *WriteError wrapping ErrTooManyFields, whose Field is the first field past
+// the limit, before anything is written.
+//
+// Setting WindowsLineEndings produces output for legacy Windows tools: records
+// are separated by "\r\n" instead of RecordSeparator, the last record has no
+// terminator, and carriage return and newline characters within fields are
+// escaped.
+//
+// Setting LengthPrefixed makes a Writer write fields in the length-prefixed
+// framing that Readers with LengthPrefixed set expect: every field's content
+// is written as is after its length and a Separator, and Quote, AlwaysQuote,
+// and SplitLimit have no effect.
+//
+// Setting EscapeNonASCII makes a Writer's output pure ASCII (provided that its
+// escape character is ASCII) by writing every rune at or above U+0080 as an
+// escape character followed by 'u' and four hexadecimal digits or, for runes
+// above U+FFFF, by 'U' and eight.  Readers with UnicodeEscapes set decode
+// them.  Invalid UTF-8 bytes are written as escaped U+FFFD, and 'u' and 'U'
+// must not be protected with ProtectRune.
+//
+// Setting NormalizeFieldNewlines makes a Writer replace each "\r\n" and each
+// lone "\r" within a field with "\n" before escaping the field, so that text
+// from different platforms is written with consistent line endings.
+//
+// If Base64Fields is set, a Writer encodes each field whose index (counting
+// from zero) it reports true for in standard base64 before writing it, so that
+// binary fields (such as those passed to WriteRaw) need no escaping.
+//
+// If RejectNUL is set, a Writer refuses to write records with a field
+// containing a NUL byte (after any base64 encoding), returning a *WriteError
+// wrapping ErrNUL that identifies the record and field.
+//
+// If Comment is nonzero, a Writer escapes it (or, without an escape
+// character, quotes the field) at the start of each record's first field so
+// that Readers with the same Comment read the record as data rather than
+// skipping it as a comment.
+//
+// If RawFieldMarker is nonzero and MarkRawFields is set, a Writer writes each
+// field whose index (counting from zero) MarkRawFields reports true for as a
+// raw field: RawFieldMarker followed by the field as is, which Readers with
+// the same RawFieldMarker return verbatim.  Write returns a *WriteError
+// wrapping ErrRawField for a raw field that contains a separator or record
+// separator.  Other fields that begin with RawFieldMarker have it escaped.
+//
+// If ContinueOnError is set, WriteRecords and WriteAll don't stop at the
+// first record that fails to be written: they write every record they can
+// and return the errors for the others (and any error from flushing) joined
+// with errors.Join.  Records rejected by validation, such as those with more
+// than SplitLimit fields or with NUL bytes under RejectNUL, are rejected
+// before any of their fields are written, so they leave no partial output.
 type Writer struct {
-    Escape      rune    // prefix for escaping characters
-    Separator   rune    // field delimiter/separator
-    writer      *bufio.Writer
+    Escape                 rune                    // prefix for escaping characters
+    EscapeString           string                  // multi-rune escape prefix overriding Escape
+    Separator              rune                    // field delimiter/separator
+    RecordSeparator        rune                    // record delimiter/separator
+    Quote                  rune                    // encloses fields when AlwaysQuote is set or needed
+    AlwaysQuote            bool                    // enclose every field in Quote characters
+    QuoteEmptyFields       bool                    // write empty fields as two Quote characters
+    SplitLimit             int                     // maximum number of fields per record (0 for none)
+    WindowsLineEndings     bool                    // separate records with "\r\n" and omit the final one
+    LengthPrefixed         bool                    // write length-prefixed fields instead of escaped ones
+    EscapeNonASCII         bool                    // write non-ASCII runes as Unicode escape sequences
+    NormalizeFieldNewlines bool                    // convert "\r\n" and "\r" within fields to "\n"
+    Base64Fields           func(index int) bool    // reports fields to encode in base64
+    RejectNUL              bool                    // reject fields containing NUL bytes
+    Comment                rune                    // escaped at the start of records (0 for none)
+    RawFieldMarker         rune                    // begins fields written verbatim (0 for none)
+    MarkRawFields          func(index int) bool    // reports fields to write as raw fields
+    ContinueOnError        bool                    // write past records that fail in WriteAll
+    writer                 *bufio.Writer
+    closer                 io.Closer
+    headerWritten          bool
+    protected              map[rune]bool
+    verbatim               map[int]bool
+    unterminated           bool
+    flushEvery             int
+    recordsWritten         int
+    recordsAttempted       int64
+    flushInterval          time.Duration
+    lastFlush              time.Time
+    now                    func() time.Time
+    dedup                  bool
+    dedupIndex             int
+    lastKey                string
+    hasLastKey             bool
+}
+
+// A Dialect describes the characters that a Reader or Writer uses to escape,
+// quote, and separate fields and to mark comment lines, along with the
+// Reader's whitespace trimming, which Writers ignore.  A Dialect obtained
+// from a Reader can be passed to a Writer's SetDialect method (and vice
+// versa) to read and write records with identical settings.
+type Dialect struct {
+    Escape            rune      // prefix for escaping characters (0 for none)
+    EscapeString      string    // multi-rune escape prefix overriding Escape
+    Separator         rune      // field delimiter/separator
+    RecordSeparator   rune      // record delimiter/separator
+    Quote             rune      // encloses quoted fields (0 for none)
+    Comment           rune      // begins comment lines (0 for none)
+    TrimLeadingSpace  bool      // trim unescaped whitespace from field starts
+    TrimTrailingSpace bool      // trim unescaped whitespace from field ends
+    TrimCutset        string    // whitespace to trim (empty for unicode.IsSpace)
+}
+
+// Validate reports whether d's characters can delimit records unambiguously.
+// It returns ErrEmptyRecordSeparator if d.RecordSeparator is zero or not a
+// valid rune and ErrRecordSeparatorConflict if d.RecordSeparator is the same
+// as d.Separator or the escape character (the first rune of d.EscapeString if
+// it isn't empty and d.Escape otherwise).  It returns ErrSeparatorConflict if
+// d.Separator is the escape character.
+func (d Dialect) Validate() error {
+    if d.RecordSeparator == 0 || !utf8.ValidRune(d.RecordSeparator) {
+        return ErrEmptyRecordSeparator
+    }
+    if d.RecordSeparator == d.escapeRune() || d.RecordSeparator == d.Separator {
+        return ErrRecordSeparatorConflict
+    }
+    if d.Separator == d.escapeRune() {
+        return ErrSeparatorConflict
+    }
+    return nil
+}
+
+// escapeRune returns the first rune of d's escape prefix.
+func (d Dialect) escapeRune() rune {
+    if d.EscapeString != "" {
+        r, _ := utf8.DecodeRuneInString(d.EscapeString)
+        return r
+    }
+    return d.Escape
+}
+
+// escapeLen returns the length of d's escape prefix in bytes.
+func (d Dialect) escapeLen() int {
+    if d.EscapeString != "" {
+        return len(d.EscapeString)
+    }
+    return utf8.RuneLen(d.Escape)
 }
 
 // NewReader returns a new Reader that reads from r.
 func NewReader(r io.RuneReader) *Reader {
+    closer, _ := r.(io.Closer)
     return &Reader {
-        Escape:    '\\',
-        Separator: ':',
-        reader:    r,
+        Escape:          '\\',
+        Separator:       ':',
+        RecordSeparator: '\n',
+        SkipBOM:         true,
+        reader:          r,
+        closer:          closer,
+    }
+}
+
+// NewReaderSeeker returns a new Reader that reads from r through a
+// bufio.Reader.  Unlike a Reader returned by NewReader for such a
+// bufio.Reader, its SeekRecord method can seek r, so this is the way to
+// read a seekable file whose records are revisited.  The Reader's Close
+// method closes r if r implements io.Closer.
+func NewReaderSeeker(r io.ReadSeeker) *Reader {
+    reader := NewReader(bufio.NewReader(r))
+    reader.closer, _ = r.(io.Closer)
+    reader.seeker = r
+    return reader
+}
+
+// tsvDialect is the dialect of NewTSVReader and NewTSVWriter.
+var tsvDialect = Dialect {Escape: '\\', Separator: '\t', RecordSeparator: '\n'}
+
+// NewTSVReader returns a new Reader that reads tab-separated values from r:
+// fields are separated by tabs and records by newlines, and a reverse solidus
+// ('\\') escapes the next character, so fields may contain escaped tabs and
+// newlines.
+func NewTSVReader(r io.RuneReader) *Reader {
+    reader := NewReader(r)
+    reader.SetDialect(tsvDialect)
+    return reader
+}
+
+// NewReaderGzip returns a new Reader that reads gzip-compressed DSV data from
+// r.  It returns any error encountered while reading the gzip header.  The
+// Reader's Close method closes the gzip stream and then r if r implements
+// io.Closer.
+func NewReaderGzip(r io.Reader) (*Reader, error) {
+    gz, err := gzip.NewReader(r)
+    if err != nil {
+        return nil, err
+    }
+    reader := NewReader(bufio.NewReader(gz))
+    reader.closer = closerFunc(func() error {
+        err := gz.Close()
+        if closer, ok := r.(io.Closer); ok {
+            if closeErr := closer.Close(); err == nil {
+                err = closeErr
+            }
+        }
+        return err
+    })
+    return reader, nil
+}
+
+// NewMultiReader returns a new Reader that reads the concatenation of readers
+// as a single stream of DSV data.  Records, fields, escape sequences, and
+// UTF-8 sequences may span the boundaries between readers.  The Reader's Close
+// method closes every reader that implements io.Closer and returns the first
+// error encountered.
+func NewMultiReader(readers ...io.Reader) *Reader {
+    reader := NewReader(bufio.NewReader(io.MultiReader(readers...)))
+    reader.closer = closerFunc(func() (err error) {
+        for _, r := range readers {
+            if closer, ok := r.(io.Closer); ok {
+                if closeErr := closer.Close(); err == nil {
+                    err = closeErr
+                }
+            }
+        }
+        return
+    })
+    return reader
+}
+
+// closerFunc adapts a function to the io.Closer interface.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+    return f()
+}
+
+// Close closes r's source if it implements io.Closer.  Otherwise Close does
+// nothing and returns nil.
+func (r *Reader) Close() error {
+    if r.closer == nil {
+        return nil
+    }
+    return r.closer.Close()
+}
+
+// Dialect returns r's current dialect settings.
+func (r *Reader) Dialect() Dialect {
+    return Dialect {
+        Escape:            r.Escape,
+        EscapeString:      r.EscapeString,
+        Separator:         r.Separator,
+        RecordSeparator:   r.RecordSeparator,
+        Quote:             r.Quote,
+        Comment:           r.Comment,
+        TrimLeadingSpace:  r.TrimLeadingSpace,
+        TrimTrailingSpace: r.TrimTrailingSpace,
+        TrimCutset:        r.TrimCutset,
     }
 }
 
+// SetDialect changes r's dialect settings to those of d.
+func (r *Reader) SetDialect(d Dialect) {
+    r.Escape = d.Escape
+    r.EscapeString = d.EscapeString
+    r.Separator = d.Separator
+    r.RecordSeparator = d.RecordSeparator
+    r.Quote = d.Quote
+    r.Comment = d.Comment
+    r.TrimLeadingSpace = d.TrimLeadingSpace
+    r.TrimTrailingSpace = d.TrimTrailingSpace
+    r.TrimCutset = d.TrimCutset
+}
+
+// Validate reports whether r's settings are valid.  See Dialect.Validate.
+func (r *Reader) Validate() error {
+    return r.Dialect().Validate()
+}
+
 // Read reads one record from r.  The record is a slice of strings with each
 // string representing one field.  err is nil if no errors occur or EOF is
 // reached.  (EOF is not treated as an error.)
 func (r *Reader) Read() (fields []string, err error) {
-    var c rune
-    var isEscaping bool
-
-    // Eliminate leading newlines.
-    for {
-        c, _, err = r.reader.ReadRune()
-        if err == io.EOF {
-            return nil, nil
+    if err = r.Validate(); err != nil {
+        return nil, err
+    }
+    if r.field.Cap() < r.InitialFieldCap {
+        r.field.Grow(r.InitialFieldCap)
+    }
+    defer func() {
+        if r.OnBlankLines != nil && r.blankLines > 0 {
+            r.OnBlankLines(r.blankLines)
         }
-        if err != nil {
-            return nil, err
+    }()
+    defer func() {
+        if err == nil && fields != nil && r.columnOrder != nil {
+            fields, err = r.reorder(fields)
         }
-        if c != '\n' {
+    }()
+    defer func() {
+        if err != nil || !r.RejectNUL {
+            return
+        }
+        for n, field := range fields {
+            if strings.IndexByte(field, 0) >= 0 {
+                err = &ParseError {
+                    Record: r.records,
+                    Field:  n + 1,
+                    Offset: r.recordOffset,
+                    Err:    ErrNUL,
+                }
+                return
+            }
+        }
+    }()
+    defer func() {
+        if err == nil && fields != nil && r.Base64Fields != nil {
+            err = r.decodeBase64(fields)
+        }
+    }()
+    defer func() {
+        if err == nil && fields != nil && r.FieldsPerRecord > 0 && len(fields) != r.FieldsPerRecord {
+            err = &ParseError {
+                Record: r.records,
+                Field:  1,
+                Offset: r.recordOffset,
+                Err:    ErrFieldCount,
+            }
+        }
+    }()
+    if fields, err = r.readRecord(); r.RecordContinuation == nil {
+        return
+    }
+    records, recordOffset, physicalLines := r.records, r.recordOffset, r.physicalLines
+    rawFields := append([]string(nil), r.rawFields...)
+    raw := append([]byte(nil), r.raw.Bytes()...)
+    for err == nil && len(fields) > 0 && r.RecordContinuation(fields[len(fields) - 1]) {
+        var next []string
+        if next, err = r.readRecord(); next == nil {
             break
         }
+        fields = append(fields, next...)
+        physicalLines += r.physicalLines
+        rawFields = append(rawFields, r.rawFields...)
+        raw = append(append(raw, string(r.RecordSeparator)...), r.raw.Bytes()...)
+    }
+    r.records, r.recordOffset, r.physicalLines = records, recordOffset, physicalLines
+    if r.KeepRawFields {
+        r.rawFields = rawFields
     }
+    if r.KeepRaw {
+        r.raw.Reset()
+        r.raw.Write(raw)
+    }
+    return
+}
 
-    defer r.field.Reset()
+// readRecord reads one physical record for Read, which applies the settings
+// that act on whole records.  It sets r.midRecord if it stops at an error
+// before the end of the record.
+func (r *Reader) readRecord() (fields []string, err error) {
+    defer func() {
+        r.midRecord = err != nil
+    }()
+    if r.LengthPrefixed {
+        fields, err = r.readLengthPrefixed()
+        if r.fieldLimit > 0 && len(fields) > r.fieldLimit {
+            fields = fields[:r.fieldLimit]
+        }
+        if r.KeepRawFields {
+            r.rawFields = append(r.rawFields[:0], fields...)
+        }
+        return
+    }
+    if r.Split != nil {
+        return r.readSplit()
+    }
 
-    // Parse the record (all fields up to the first unescaped newline).
+    r.inRecord = false
+    t := Tokenizer {reader: r}
     for {
-        if isEscaping {
-            r.field.WriteRune(c)
-            isEscaping = false
-        } else {
-            switch c {
-                case r.Separator:
-                    fields = append(fields, r.field.String())
-                    r.field.Reset()
-                case r.Escape:
-                    isEscaping = true
-                case '\n':
-                    fields = append(fields, r.field.String())
-                    return fields, nil
-                default:
-                    r.field.WriteRune(c)
+        token, err := t.next()
+        if err != nil {
+            if !r.inRecord {
+                return nil, err
             }
+            r.inRecord = false
+            return append(fields, token.Value), err
         }
-        c, _, err = r.reader.ReadRune()
-        if err == io.EOF {
-            fields = append(fields, r.field.String())
-            break
+        switch token.Kind {
+            case TokenEOF:
+                return nil, nil
+            case TokenRecordEnd:
+                return r.trimTrailingEmptyFields(fields), nil
+        }
+        fields = append(fields, token.Value)
+        if r.fieldLimit > 0 && len(fields) == r.fieldLimit && !t.recordEnd {
+            r.inRecord = false
+            return r.trimTrailingEmptyFields(fields), r.skipRecord(true)
+        }
+    }
+}
+
+// LimitFields makes Read return only the first n fields of each record.  The
+// rest of each record is scanned to find its end but not decoded, which saves
+// time on wide records when only the leading columns are needed.  An n of zero
+// or less restores the default of returning every field.  Limited records
+// count as having n fields (or fewer) for FieldsPerRecord, and RawRecord then
+// holds the raw text of a record only up to the end of its returned fields.
+func (r *Reader) LimitFields(n int) {
+    r.fieldLimit = n
+}
+
+// SetColumnOrder makes Read return the fields of each record in the order
+// given by order: field n of each returned record is field order[n] (counting
+// from zero) of the record as read, so order [2, 0, 1] moves the third column
+// to the front.  Columns may be repeated or omitted.  If an index is negative
+// or not less than the number of fields in a record, Read returns that record
+// as read along with ErrColumnIndex, unless r.PadShortRecords is set and the
+// index is just too large for the record, in which case the index selects an
+// empty field.  An empty order restores the original order.  NextField
+// ignores the column order.
+func (r *Reader) SetColumnOrder(order []int) {
+    r.columnOrder = append([]int(nil), order...)
+}
+
+// reorder returns fields in r's column order.
+func (r *Reader) reorder(fields []string) ([]string, error) {
+    reordered := make([]string, len(r.columnOrder))
+    for n, index := range r.columnOrder {
+        if index >= len(fields) && index >= 0 && r.PadShortRecords {
+            continue
+        }
+        if index < 0 || index >= len(fields) {
+            return fields, ErrColumnIndex
+        }
+        reordered[n] = fields[index]
+    }
+    return reordered, nil
+}
+
+// decodeBase64 replaces the fields selected by r.Base64Fields with the bytes
+// that they encode in base64.
+func (r *Reader) decodeBase64(fields []string) error {
+    for n, field := range fields {
+        if !r.Base64Fields(n) {
+            continue
         }
+        decoded, err := base64.StdEncoding.DecodeString(field)
         if err != nil {
-            fields = append(fields, r.field.String())
-            break
+            return &ParseError {
+                Record: r.records,
+                Field:  n + 1,
+                Offset: r.recordOffset,
+                Err:    err,
+            }
         }
+        fields[n] = string(decoded)
     }
-    return
+    return nil
 }
 
-// ReadAll reads all remaining records from r.  Each record is a slice of
-// fields, one string per field.  err is set to nil if no errors occur or
-// EOF is reached.  (EOF is not treated as an error.)
-func (r *Reader) ReadAll() (records [][]string, err error) {
-    for {
-        record, err := r.Read()
+// NextField reads the next field from r, one field per call, without
+// allocating a slice for the record.  endOfRecord reports whether the field
+// is the last of its record, in which case the next call begins a new record.
+// NextField is built on a Tokenizer and recovers from errors as Next does.
+// Fields are parsed as by Read, with which NextField shares its parser, but
+// NextField doesn't trim trailing empty fields or check r.FieldsPerRecord.
+// At the end of the input, NextField returns io.EOF.  It returns
+// ErrUnsupportedFraming if r.LengthPrefixed or r.Split is set.  Calls to Read
+// and NextField may be mixed only between records.
+func (r *Reader) NextField() (field string, endOfRecord bool, err error) {
+    t := Tokenizer {reader: r}
+    token, err := t.Next()
+    if token.Kind == TokenEOF {
+        return "", false, io.EOF
+    }
+    return token.Value, t.recordEnd, err
+}
+
+// nextField reads the next field for NextField and Read, beginning a record
+// (and skipping the blank lines before it) if r isn't within one.  If an
+// error occurs, r.inRecord reports whether it occurred within a record, and
+// the caller must clear it.
+func (r *Reader) nextField() (field string, endOfRecord bool, err error) {
+    var c rune
+    if !r.inRecord {
+        var blank bool
+        if c, blank, err = r.startRecord(); err != nil || blank {
+            return "", blank, err
+        }
+        r.inRecord = true
+    } else if c, _, err = r.readRune(); err != nil {
+        r.keepRawField("")
         if err == io.EOF {
-            return append(records, record), nil
+            r.inRecord = false
+            return "", true, nil
         }
+        return "", false, err
+    }
+    field, endOfRecord, err = r.readField(c)
+    if endOfRecord {
+        r.inRecord = false
+    }
+    return
+}
+
+// startRecord skips blank lines (leading record separators) and begins a new
+// record, returning its first rune.  At the end of the input, it returns
+// io.EOF.  blank reports that the record is a blank line that Read returns
+// because r.KeepLeadingBlanks or r.PreserveEmptyRecords is set.
+func (r *Reader) startRecord() (c rune, blank bool, err error) {
+    var size int
+    r.stats.RecordRunes, r.stats.RecordBytes = 0, 0
+    r.blankLines = 0
+    r.fieldNumber = 1
+    r.rawFields = r.rawFields[:0]
+    for {
+        c, size, err = r.readRune()
         if err != nil {
-            return nil, err
+            return
         }
-        if record == nil {
-            return records, nil
+        if r.Comment != 0 && c == r.Comment {
+            if err = r.skipComment(); err != nil {
+                return
+            }
+            continue
         }
-        records = append(records, record)
+        if c != r.RecordSeparator {
+            break
+        }
+        if r.PreserveEmptyRecords || r.KeepLeadingBlanks && !r.seenRecord {
+            r.recordOffset = r.offset - int64(size)
+            r.records++
+            if r.CollectStats {
+                r.stats.Records++
+            }
+            r.raw.Reset()
+            r.keepRawField("")
+            return c, true, nil
+        }
+        r.blankLines++
     }
+    r.seenRecord = true
+    r.recordOffset = r.offset - int64(size)
+    r.records++
+    if r.CollectStats {
+        r.stats.Records++
+    }
+    r.raw.Reset()
+    r.physicalLines = 1
+    r.fieldOffset = r.recordOffset
+    return
 }
 
-// NewWriter returns a Writer that writes to w.
-func NewWriter(w io.Writer) *Writer {
-    return &Writer {
-        Escape:    '\\',
-        Separator: ':',
-        writer:    bufio.NewWriter(w),
+// isTrimSpace reports whether c is whitespace for TrimLeadingSpace and
+// TrimTrailingSpace.
+func (r *Reader) isTrimSpace(c rune) bool {
+    if r.TrimCutset == "" {
+        return unicode.IsSpace(c)
     }
+    return strings.ContainsRune(r.TrimCutset, c)
 }
 
-// Error reports any error that occurred during the last Flush or Write.
-func (w *Writer) Error() error {
-    _, err := w.writer.Write(nil)
-    return err
+// skipComment skips the rest of a comment line, including its record
+// separator.  It returns io.EOF if the input ends first.
+func (r *Reader) skipComment() error {
+    for {
+        c, _, err := r.readRune()
+        if err != nil || c == r.RecordSeparator {
+            return err
+        }
+    }
 }
 
-// Flush writes buffered data to w's underlying io.Writer.  Call Error to
-// check for errors.
-func (w *Writer) Flush() {
-    w.writer.Flush()
-}
+// readField reads one field of the current record, beginning with its first
+// rune c, up to the next unescaped separator or record separator.  end
+// reports whether the field ends the record.  If an error occurs, readField
+// returns the part of the field read so far.
+func (r *Reader) readField(c rune) (field string, end bool, err error) {
+    var isEscaping, inSpace, isRaw bool
+    var size, keep, escapeStart, terminator int
+    fieldStart := true
 
-// Write writes a single record to w.  The record is a slice of strings
-// representing its fields, one string per field.  Characters within the
-// fields are escaped as necessary.
-func (w *Writer) Write(record []string) (err error) {
-    for n, field := range record {
-        if n > 0 {
-            if _, err = w.writer.WriteRune(w.Separator); err != nil {
-                return
+    defer r.field.Reset()
+    if r.TrimTrailingSpace {
+        defer func() {
+            if err == nil {
+                field = field[:keep]
             }
+        }()
+    }
+    rawStart := r.raw.Len()
+    defer func() {
+        if !r.KeepRawFields {
+            return
+        }
+        raw := r.raw.Bytes()[rawStart:]
+        if !end && err == nil {
+            raw = raw[:len(raw) - terminator]
+        }
+        r.rawFields = append(r.rawFields, string(raw))
+    }()
+    for {
+        rawLen := r.raw.Len()
+        if r.keepRaw() && (isEscaping || c != r.RecordSeparator) {
+            r.writeRune(&r.raw, c)
         }
-        for _, r := range field {
-            switch r {
-                case w.Escape:
-                    _, err = w.writer.WriteRune(w.Escape)
-                    if err == nil {
-                        _, err = w.writer.WriteRune(w.Escape)
+        atFieldStart := fieldStart
+        fieldStart = false
+        collapse := inSpace
+        inSpace = false
+        if isEscaping {
+            isEscaping = false
+            if r.ResolveEscape != nil {
+                emit, isSeparator, consume := r.ResolveEscape(c)
+                if !consume {
+                    r.raw.Truncate(rawLen)
+                    r.unreadRunes([]pendingRune {{c, size, r.invalid, r.invalidByte, nil}})
+                } else if c == r.RecordSeparator {
+                    r.physicalLines++
+                }
+                if isSeparator {
+                    terminator = r.raw.Len() - escapeStart
+                    field = r.field.String()
+                    r.fieldNumber++
+                    r.fieldOffset = r.offset
+                    return field, false, nil
+                }
+                if emit >= 0 {
+                    r.writeRune(&r.field, emit)
+                }
+            } else if r.UnicodeEscapes && (c == 'u' || c == 'U') {
+                if err = r.readUnicodeEscape(c); err != nil {
+                    return r.field.String(), false, err
+                }
+            } else {
+                if c == r.RecordSeparator {
+                    r.physicalLines++
+                }
+                r.writeRune(&r.field, c)
+            }
+            keep = r.field.Len()
+        } else if isRaw && c != r.Separator && c != r.RecordSeparator {
+            r.writeRune(&r.field, c)
+            keep = r.field.Len()
+        } else {
+            switch {
+                case c == r.Separator && (r.SplitLimit <= 0 || r.fieldNumber < r.SplitLimit):
+                    terminator = r.raw.Len() - rawLen
+                    field = r.field.String()
+                    r.fieldNumber++
+                    r.fieldOffset = r.offset
+                    return field, false, nil
+                case atFieldStart && r.RawFieldMarker != 0 && c == r.RawFieldMarker:
+                    isRaw = true
+                case atFieldStart && r.Quote != 0 && c == r.Quote:
+                    if err = r.readQuoted(r.fieldOffset); err != nil {
+                        return r.field.String(), false, err
                     }
-                case w.Separator:
-                    _, err = w.writer.WriteRune(w.Escape)
-                    if err == nil {
-                        _, err = w.writer.WriteRune(w.Separator)
+                    keep = r.field.Len()
+                case !r.verbatim() && r.isEscape(c):
+                    isEscaping = true
+                    escapeStart = rawLen
+                case c == r.RecordSeparator:
+                    return r.field.String(), true, nil
+                case r.TrimLeadingSpace && r.field.Len() == 0 && r.isTrimSpace(c):
+                    fieldStart = atFieldStart
+                case r.CollapseInnerWhitespace && unicode.IsSpace(c):
+                    if !collapse {
+                        r.field.WriteByte(' ')
                     }
-                case '\n':
-                    _, err = w.writer.WriteRune(w.Escape)
-                    if err == nil {
-                        err = w.writer.WriteByte('\n')
+                    inSpace = true
+                    if !r.isTrimSpace(c) {
+                        keep = r.field.Len()
                     }
                 default:
-                    _, err = w.writer.WriteRune(r)
+                    r.writeRune(&r.field, c)
+                    if !r.isTrimSpace(c) {
+                        keep = r.field.Len()
+                    }
             }
-            if err != nil {
-                return
+        }
+        if r.MaxFieldBytes > 0 && r.field.Len() > r.MaxFieldBytes {
+            return r.field.String(), false, r.parseError(ErrFieldTooLarge, r.fieldOffset)
+        }
+        c, size, err = r.readRune()
+        if err == io.EOF {
+            if isEscaping && r.OnWarning != nil {
+                r.OnWarning(r.parseError(ErrDanglingEscape, r.offset - int64(r.Dialect().escapeLen())))
             }
+            return r.field.String(), true, nil
+        }
+        if err != nil {
+            return r.field.String(), false, err
         }
     }
-    err = w.writer.WriteByte('\n')
-    return
 }
 
-// WriteAll writes multiple records to w and calls Flush.
-func (w *Writer) WriteAll(records [][]string) (err error) {
-    for _, record := range records {
-        if err = w.Write(record); err != nil {
-            return
+// readQuoted reads the rest of a field that began with r.Quote at byte
+// offset quoteOffset into r.field for Read, and then pushes back the rune
+// following the closing quote.  If the field exceeds r.MaxQuotedFieldBytes,
+// readQuoted instead makes the opening quote an ordinary character and pushes
+// back everything after it.
+func (r *Reader) readQuoted(quoteOffset int64) error {
+    var read []pendingRune
+    var n int
+
+    rawLen := r.raw.Len()
+    for {
+        c, size, err := r.readRune()
+        read = append(read, pendingRune {c, size, r.invalid, r.invalidByte, err})
+        if err == io.EOF {
+            r.unreadRunes(read[len(read) - 1:])
+            return nil
+        }
+        if err != nil {
+            return err
         }
+        if n += size; r.MaxQuotedFieldBytes > 0 && n > r.MaxQuotedFieldBytes {
+            r.unreadRunes(read)
+            r.field.Reset()
+            r.field.WriteRune(r.Quote)
+            r.raw.Truncate(rawLen)
+            if r.OnWarning != nil {
+                r.OnWarning(r.parseError(ErrUnterminatedQuote, quoteOffset))
+            }
+            return nil
+        }
+        if c != r.Quote {
+            if c == r.RecordSeparator {
+                r.physicalLines++
+            }
+            if r.keepRaw() {
+                r.writeRune(&r.raw, c)
+            }
+            r.writeRune(&r.field, c)
+            if r.MaxFieldBytes > 0 && r.field.Len() > r.MaxFieldBytes {
+                return r.parseError(ErrFieldTooLarge, quoteOffset)
+            }
+            continue
+        }
+
+        // A quote either closes the field or is doubled.
+        c, size, err = r.readRune()
+        read = append(read, pendingRune {c, size, r.invalid, r.invalidByte, err})
+        if err != nil || c != r.Quote {
+            r.unreadRunes(read[len(read) - 1:])
+            if r.keepRaw() {
+                r.raw.WriteRune(r.Quote)
+            }
+            return nil
+        }
+        n += size
+        if r.keepRaw() {
+            r.raw.WriteRune(r.Quote)
+            r.raw.WriteRune(r.Quote)
+        }
+        r.field.WriteRune(r.Quote)
     }
-    return w.writer.Flush()
+}
+
+// readLengthPrefixed reads a record of length-prefixed fields for Read.
+func (r *Reader) readLengthPrefixed() (fields []string, err error) {
+    var c rune
+    var size int
+    var length strings.Builder
+
+    byteReader, ok := r.reader.(io.ByteReader)
+    if !ok {
+        return nil, ErrNotByteReader
+    }
+    defer r.field.Reset()
+    r.blankLines = 0
+    for {
+        if c, size, err = r.readRune(); err == io.EOF {
+            return fields, nil
+        }
+        if err != nil {
+            return
+        }
+        if c == r.RecordSeparator {
+            if fields != nil {
+                return
+            }
+            r.blankLines++
+            continue
+        }
+        if fields == nil {
+            r.recordOffset = r.offset - int64(size)
+            r.records++
+            fields = []string {}
+        }
+        r.fieldNumber = len(fields) + 1
+        fieldOffset := r.offset - int64(size)
+
+        // Read the field's length up to the separator.
+        length.Reset()
+        for c != r.Separator {
+            if c < '0' || c > '9' {
+                return fields, r.parseError(ErrInvalidFieldLength, r.offset - int64(size))
+            }
+            length.WriteRune(c)
+            if c, size, err = r.readRune(); err == io.EOF {
+                return fields, r.parseError(io.ErrUnexpectedEOF, r.offset)
+            }
+            if err != nil {
+                return
+            }
+        }
+        n, convErr := strconv.Atoi(length.String())
+        if convErr != nil {
+            return fields, r.parseError(ErrInvalidFieldLength, r.offset - int64(size))
+        }
+        if r.MaxFieldBytes > 0 && n > r.MaxFieldBytes {
+            return fields, r.parseError(ErrFieldTooLarge, fieldOffset)
+        }
+
+        // Copy the field's content as is.
+        r.field.Reset()
+        for ; n > 0; n-- {
+            var b byte
+            if b, err = byteReader.ReadByte(); err == io.EOF {
+                return fields, r.parseError(io.ErrUnexpectedEOF, r.offset)
+            }
+            if err != nil {
+                return
+            }
+            r.offset++
+            if r.CollectStats {
+                r.stats.Bytes++
+                r.stats.RecordBytes++
+            }
+            r.field.WriteByte(b)
+        }
+        fields = append(fields, r.field.String())
+    }
+}
+
+// readSplit reads a record framed by r.Split for Read.
+func (r *Reader) readSplit() ([]string, error) {
+    if r.scanner == nil {
+        source, ok := r.reader.(io.Reader)
+        if !ok {
+            source = runeSource {r.reader}
+        }
+        r.scanner = bufio.NewScanner(source)
+        r.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+            advance, token, err := r.Split(data, atEOF)
+            if token != nil {
+                r.recordOffset = r.offset
+            }
+            r.offset += int64(advance)
+            return advance, token, err
+        })
+    }
+    r.blankLines = 0
+    for r.scanner.Scan() {
+        record := r.scanner.Text()
+        if !r.started {
+            r.started = true
+            if r.SkipBOM {
+                record = strings.TrimPrefix(record, "\uFEFF")
+            }
+        }
+        if record == "" {
+            r.blankLines++
+            continue
+        }
+        r.records++
+        if r.CollectStats {
+            r.stats.Records++
+        }
+        if r.keepRaw() {
+            r.raw.Reset()
+            r.raw.WriteString(record)
+        }
+        r.rawFields = r.rawFields[:0]
+        r.physicalLines = strings.Count(record, string(r.RecordSeparator)) + 1
+        return r.splitFields(record)
+    }
+    return nil, r.scanner.Err()
+}
+
+// splitFields splits a record framed by r.Split into fields, interpreting
+// escape characters and separators as Read does.
+func (r *Reader) splitFields(record string) (fields []string, err error) {
+    var isEscaping, inSpace bool
+    var fieldIndex int
+
+    defer r.field.Reset()
+    r.fieldNumber = 1
+    for i := 0; i < len(record); {
+        c, size := utf8.DecodeRuneInString(record[i:])
+        if c == utf8.RuneError && size == 1 && r.OnInvalidUTF8 == InvalidUTF8Error {
+            r.keepRawField(record[fieldIndex:i])
+            fields = append(fields, r.field.String())
+            return fields, &ParseError {
+                Record: r.records,
+                Field:  r.fieldNumber,
+                Offset: r.recordOffset + int64(i),
+                Err:    ErrInvalidUTF8,
+            }
+        }
+        collapse := inSpace
+        inSpace = false
+        switch {
+            case isEscaping && r.UnicodeEscapes && (c == 'u' || c == 'U'):
+                isEscaping = false
+                code, n := r.splitUnicodeEscape(record[i:])
+                if n == 0 {
+                    r.keepRawField(record[fieldIndex:i])
+                    fields = append(fields, r.field.String())
+                    return fields, &ParseError {
+                        Record: r.records,
+                        Field:  r.fieldNumber,
+                        Offset: r.recordOffset + int64(i),
+                        Err:    ErrInvalidUnicode,
+                    }
+                }
+                r.field.WriteRune(code)
+                size = n
+            case isEscaping:
+                isEscaping = false
+                r.writeSplitRune(record[i:i + size], c)
+            case c == r.Separator && (r.SplitLimit <= 0 || len(fields) < r.SplitLimit - 1):
+                r.keepRawField(record[fieldIndex:i])
+                fields = append(fields, r.field.String())
+                if len(fields) == r.fieldLimit {
+                    return r.trimTrailingEmptyFields(fields), nil
+                }
+                r.field.Reset()
+                r.fieldNumber++
+                fieldIndex = i + size
+            case r.verbatim():
+                r.writeSplitRune(record[i:i + size], c)
+            case r.EscapeString != "" && strings.HasPrefix(record[i:], r.EscapeString):
+                isEscaping = true
+                size = len(r.EscapeString)
+            case r.EscapeString == "" && c == r.Escape && r.Escape != 0:
+                isEscaping = true
+            case r.CollapseInnerWhitespace && unicode.IsSpace(c):
+                if !collapse {
+                    r.field.WriteByte(' ')
+                }
+                inSpace = true
+            default:
+                r.writeSplitRune(record[i:i + size], c)
+        }
+        if r.MaxFieldBytes > 0 && r.field.Len() > r.MaxFieldBytes {
+            r.keepRawField(record[fieldIndex:i + size])
+            fields = append(fields, r.field.String())
+            return fields, &ParseError {
+                Record: r.records,
+                Field:  r.fieldNumber,
+                Offset: r.recordOffset + int64(fieldIndex),
+                Err:    ErrFieldTooLarge,
+            }
+        }
+        i += size
+    }
+    r.keepRawField(record[fieldIndex:])
+    fields = append(fields, r.field.String())
+    return r.trimTrailingEmptyFields(fields), nil
+}
+
+// splitUnicodeEscape decodes the Unicode escape sequence (without its escape
+// character) at the start of s for splitFields.  It returns the rune and the
+// sequence's length in bytes, or zero if the sequence is malformed.
+func (r *Reader) splitUnicodeEscape(s string) (code rune, n int) {
+    n = 5
+    if s[0] == 'U' {
+        n = 9
+    }
+    if len(s) < n {
+        return 0, 0
+    }
+    for _, c := range s[1:n] {
+        digit, ok := hexDigit(c)
+        if !ok {
+            return 0, 0
+        }
+        code = code << 4 | digit
+    }
+    if !utf8.ValidRune(code) {
+        return 0, 0
+    }
+    return
+}
+
+// writeSplitRune appends c, decoded from s, to r.field for splitFields,
+// handling invalid UTF-8 bytes as writeRune does.
+func (r *Reader) writeSplitRune(s string, c rune) {
+    if c == utf8.RuneError && len(s) == 1 && r.OnInvalidUTF8 != InvalidUTF8Passthrough {
+        r.field.WriteRune(c)
+    } else {
+        r.field.WriteString(s)
+    }
+}
+
+// runeSource adapts an io.RuneReader to the io.Reader interface.
+type runeSource struct {
+    reader      io.RuneReader
+}
+
+func (s runeSource) Read(p []byte) (n int, err error) {
+    for n + utf8.UTFMax <= len(p) {
+        var c rune
+        if c, _, err = s.reader.ReadRune(); err != nil {
+            return
+        }
+        n += utf8.EncodeRune(p[n:], c)
+    }
+    return
+}
+
+// verbatim reports whether r reads the current field verbatim.
+func (r *Reader) verbatim() bool {
+    return r.VerbatimLastField && r.SplitLimit > 0 && r.fieldNumber == r.SplitLimit
+}
+
+// readUnicodeEscape reads the hexadecimal digits of a Unicode escape sequence
+// that begins with an escape character and kind ('u' or 'U') and appends the
+// rune that they denote to r.field.
+func (r *Reader) readUnicodeEscape(kind rune) error {
+    digits := 4
+    if kind == 'U' {
+        digits = 8
+    }
+    offset := r.offset - 1
+    var code rune
+    for i := 0; i < digits; i++ {
+        c, size, err := r.readRune()
+        if err == io.EOF {
+            return r.parseError(ErrInvalidUnicode, offset)
+        }
+        if err != nil {
+            return err
+        }
+        n, ok := hexDigit(c)
+        if !ok {
+            // Leave the rune, which may be a separator, for Lint to skip.
+            r.unreadRunes([]pendingRune {{c, size, r.invalid, r.invalidByte, nil}})
+            return r.parseError(ErrInvalidUnicode, offset)
+        }
+        if r.keepRaw() {
+            r.writeRune(&r.raw, c)
+        }
+        code = code << 4 | n
+    }
+    if !utf8.ValidRune(code) {
+        return r.parseError(ErrInvalidUnicode, offset)
+    }
+    r.field.WriteRune(code)
+    return nil
+}
+
+// hexDigit returns the value of the hexadecimal digit c.
+func hexDigit(c rune) (rune, bool) {
+    switch {
+        case c >= '0' && c <= '9':
+            return c - '0', true
+        case c >= 'a' && c <= 'f':
+            return c - 'a' + 10, true
+        case c >= 'A' && c <= 'F':
+            return c - 'A' + 10, true
+    }
+    return 0, false
+}
+
+// isEscape reports whether c, the rune most recently read by readRune, begins
+// an escape prefix.  If r.EscapeString is set, isEscape reads ahead to match
+// the rest of the prefix, consuming it if it matches and pushing back the
+// runes it read otherwise.
+func (r *Reader) isEscape(c rune) bool {
+    if r.EscapeString == "" {
+        return c == r.Escape && r.Escape != 0
+    }
+    first, n := utf8.DecodeRuneInString(r.EscapeString)
+    if c != first {
+        return false
+    }
+    var read []pendingRune
+    for _, e := range r.EscapeString[n:] {
+        next, size, err := r.readRune()
+        read = append(read, pendingRune {next, size, r.invalid, r.invalidByte, err})
+        if err != nil || next != e {
+            r.unreadRunes(read)
+            r.invalid = false
+            return false
+        }
+    }
+    if r.keepRaw() {
+        r.raw.WriteString(r.EscapeString[n:])
+    }
+    return true
+}
+
+// unreadRunes pushes runes back so that readRune returns them again, in
+// order, before reading anything else.
+func (r *Reader) unreadRunes(runes []pendingRune) {
+    for _, p := range runes {
+        r.offset -= int64(p.size)
+        if r.DebugContext && p.err == nil {
+            n := len(r.context) - len(r.contextBytes(p.c, p.invalid, p.invalidByte))
+            if n < 0 {
+                n = 0
+            }
+            r.context = r.context[:n]
+        }
+    }
+    r.pending = append(append([]pendingRune(nil), runes...), r.pending...)
+}
+
+// trimTrailingEmptyFields removes empty fields from the end of fields as
+// r.TrimTrailingEmptyFields and r.TrimAllTrailingEmptyFields require.
+func (r *Reader) trimTrailingEmptyFields(fields []string) []string {
+    if r.TrimAllTrailingEmptyFields {
+        for len(fields) > 1 && fields[len(fields) - 1] == "" {
+            fields = fields[:len(fields) - 1]
+        }
+    } else if r.TrimTrailingEmptyFields && len(fields) > 1 && fields[len(fields) - 1] == "" {
+        fields = fields[:len(fields) - 1]
+    }
+    if len(r.rawFields) > len(fields) {
+        r.rawFields = r.rawFields[:len(fields)]
+    }
+    return fields
+}
+
+// LeadingBlankLines returns the number of record separators that the most
+// recent call to Read skipped before the record it returned, not counting the
+// separator that terminated the previous record.  For newline-separated
+// records, this is the number of blank lines preceding the record.  If Read
+// reached the end of input instead of returning a record, LeadingBlankLines
+// returns the number of record separators at the end of the input.
+// Rewriting tools can use it to reproduce the spacing between records.
+func (r *Reader) LeadingBlankLines() int {
+    return r.blankLines
+}
+
+// PhysicalLines returns the number of physical lines that the record most
+// recently returned by Read spanned: one more than the number of record
+// separators within its fields, whether escaped to continue the record onto
+// the next line or enclosed in quotes.  Together with RecordOffset or a count
+// of LeadingBlankLines, it maps records back to source line numbers.
+func (r *Reader) PhysicalLines() int {
+    return r.physicalLines
+}
+
+// Stats returns the statistics that r has collected while r.CollectStats was
+// set.
+func (r *Reader) Stats() ReaderStats {
+    return r.stats
+}
+
+// ResetStats zeroes the statistics returned by Stats, for instance to measure
+// a particular section of the input.  It doesn't affect r's source, its byte
+// offset, or the record numbers reported in ParseErrors.
+func (r *Reader) ResetStats() {
+    r.stats = ReaderStats {}
+}
+
+// RecordOffset returns the byte offset at which the record most recently
+// returned by Read began.  Offsets are relative to the position of r's
+// source when r was created and include any leading record separators that
+// Read skipped.  A source positioned at a record's offset yields that record
+// when read by a new Reader.
+func (r *Reader) RecordOffset() int64 {
+    return r.recordOffset
+}
+
+// RawFields returns the raw, still-escaped text of each field of the record
+// most recently returned by Read, in parallel with the fields as parsed
+// (before Base64Fields decoding and SetColumnOrder), if r's KeepRawFields
+// field was set during that Read.  Otherwise it returns nil.  Since Writers
+// escape minimally, re-escaping a field doesn't always reproduce its raw
+// text; editors can instead write the raw text of unchanged fields back as
+// is.  The returned slice is only valid until the next call to Read.
+func (r *Reader) RawFields() []string {
+    if !r.KeepRawFields {
+        return nil
+    }
+    return r.rawFields
+}
+
+// keepRaw reports whether r retains the raw text of records.
+func (r *Reader) keepRaw() bool {
+    return r.KeepRaw || r.KeepRawFields
+}
+
+// keepRawField appends raw, the still-escaped text of a field, to the raw
+// fields of the current record if r.KeepRawFields is set.
+func (r *Reader) keepRawField(raw string) {
+    if r.KeepRawFields {
+        r.rawFields = append(r.rawFields, raw)
+    }
+}
+
+// RawRecord returns the raw, still-escaped text of the record most recently
+// returned by Read, excluding the record separator that terminated it, if r's
+// KeepRaw field was set during that Read.  Otherwise it returns nil.  The
+// returned slice is only valid until the next call to Read.
+func (r *Reader) RawRecord() []byte {
+    if !r.KeepRaw {
+        return nil
+    }
+    return r.raw.Bytes()
+}
+
+// SeekRecord positions r's source at offset, which is a record offset
+// previously returned by RecordOffset, so that the next call to Read returns
+// the record beginning there.  It returns ErrNotSeekable if r's source
+// doesn't implement io.Seeker (as a bufio.Reader doesn't, so use
+// NewReaderSeeker to read buffered files) and ErrUnsupportedFraming if r.Split is set,
+// because the Scanner that Split uses buffers input beyond the current record.
+func (r *Reader) SeekRecord(offset int64) error {
+    if r.Split != nil {
+        return ErrUnsupportedFraming
+    }
+    var seeker io.Seeker = r.seeker
+    if seeker == nil {
+        var ok bool
+        if seeker, ok = r.reader.(io.Seeker); !ok {
+            return ErrNotSeekable
+        }
+    }
+    current, err := seeker.Seek(0, io.SeekCurrent)
+    if err != nil {
+        return err
+    }
+    if r.seeker != nil {
+        current -= int64(r.reader.(*bufio.Reader).Buffered())
+    }
+    for _, p := range r.pending {
+        current -= int64(p.size)
+    }
+    if _, err = seeker.Seek(current - r.offset + offset, io.SeekStart); err != nil {
+        return err
+    }
+    if r.seeker != nil {
+        r.reader.(*bufio.Reader).Reset(r.seeker)
+    }
+    r.offset = offset
+    r.pending = nil
+    r.field.Reset()
+    r.raw.Reset()
+    r.rawFields = r.rawFields[:0]
+    r.context = r.context[:0]
+    r.inRecord = false
+    r.midRecord = false
+    return nil
+}
+
+// readRune reads a single rune from r's source and advances r's byte offset.
+// It skips a byte order mark at the start of the source if r.SkipBOM is set.
+//
+// If the rune is an invalid UTF-8 byte (for which ReadRune returns
+// utf8.RuneError and a size of one) and r's source implements io.RuneScanner
+// and io.ByteReader, readRune rereads the byte so that writeRune can copy it
+// as is.  readRune then returns a *ParseError if r.OnInvalidUTF8 is
+// InvalidUTF8Error.
+//
+// Runes pushed back by unreadRunes are returned before any others.
+func (r *Reader) readRune() (c rune, size int, err error) {
+    rejectInvalid := r.OnInvalidUTF8 == InvalidUTF8Error && !r.passthrough
+    if len(r.pending) > 0 {
+        p := r.pending[0]
+        r.pending = r.pending[1:]
+        c, size, err = p.c, p.size, p.err
+        r.offset += int64(size)
+        r.invalid, r.invalidByte = p.invalid, p.invalidByte
+    } else {
+        c, size, err = r.reader.ReadRune()
+        r.offset += int64(size)
+        r.invalid = false
+        if r.CollectStats && err == nil {
+            r.stats.Runes++
+            r.stats.Bytes += int64(size)
+            r.stats.RecordRunes++
+            r.stats.RecordBytes += int64(size)
+        }
+        if err == nil && c == utf8.RuneError && size == 1 {
+            scanner, isScanner := r.reader.(io.RuneScanner)
+            byteReader, isByteReader := r.reader.(io.ByteReader)
+            if isScanner && isByteReader && scanner.UnreadRune() == nil {
+                if r.invalidByte, err = byteReader.ReadByte(); err != nil {
+                    return
+                }
+                r.invalid = true
+            }
+        }
+        if !r.started {
+            r.started = true
+            if err == nil && c == '\uFEFF' && r.SkipBOM {
+                return r.readRune()
+            }
+        }
+    }
+    if r.DebugContext && err == nil {
+        r.context = append(r.context, r.contextBytes(c, r.invalid, r.invalidByte)...)
+        if n := 2 * debugContextBytes + utf8.UTFMax; len(r.context) > n {
+            r.context = append(r.context[:0], r.context[len(r.context) - n:]...)
+        }
+    }
+    if err == nil && c == utf8.RuneError && size == 1 && rejectInvalid {
+        err = r.parseError(ErrInvalidUTF8, r.offset - 1)
+    }
+    return
+}
+
+// debugContextBytes is the number of bytes on either side of a problem's
+// byte offset that a ParseError's Context covers.
+const debugContextBytes = 20
+
+// contextBytes returns the bytes that readRune saves for r.DebugContext when
+// it reads c.  If invalid is set, c stands for the invalid byte b.
+func (r *Reader) contextBytes(c rune, invalid bool, b byte) []byte {
+    if invalid {
+        return []byte {b}
+    }
+    return []byte(string(c))
+}
+
+// parseError returns a *ParseError for err at byte offset in the current
+// record.  If r.DebugContext is set, the error's Context holds the bytes
+// around offset: those most recently read, followed by those that r reads
+// ahead of offset and then pushes back with unreadRunes.
+func (r *Reader) parseError(err error, offset int64) *ParseError {
+    e := &ParseError {
+        Record: r.records,
+        Field:  r.fieldNumber,
+        Offset: offset,
+        Err:    err,
+    }
+    if !r.DebugContext {
+        return e
+    }
+    before := r.context
+    if len(before) > debugContextBytes {
+        before = before[len(before) - debugContextBytes:]
+    }
+    e.Context = string(before)
+
+    // Read ahead of the problem without stopping at further invalid bytes.
+    invalid, invalidByte, passthrough := r.invalid, r.invalidByte, r.passthrough
+    r.passthrough = true
+    var read []pendingRune
+    for after := 0; after < debugContextBytes; {
+        c, size, err := r.readRune()
+        read = append(read, pendingRune {c, size, r.invalid, r.invalidByte, err})
+        if err != nil {
+            break
+        }
+        b := r.contextBytes(c, r.invalid, r.invalidByte)
+        e.Context += string(b)
+        after += len(b)
+    }
+    r.unreadRunes(read)
+    r.invalid, r.invalidByte, r.passthrough = invalid, invalidByte, passthrough
+    return e
+}
+
+// writeRune appends c, the rune most recently read by readRune, to buffer.
+// Invalid UTF-8 bytes are appended as is when collecting raw text (buffer is
+// r.raw), when r.passthrough is set, or when r.OnInvalidUTF8 is
+// InvalidUTF8Passthrough, and as utf8.RuneError otherwise.
+func (r *Reader) writeRune(buffer *bytes.Buffer, c rune) {
+    if r.invalid && (r.passthrough || r.OnInvalidUTF8 == InvalidUTF8Passthrough || buffer == &r.raw) {
+        buffer.WriteByte(r.invalidByte)
+    } else {
+        buffer.WriteRune(c)
+    }
+}
+
+// ReadRaw reads one record from r like Read but returns its fields as byte
+// slices.  Unlike Read, which replaces invalid UTF-8 bytes with
+// utf8.RuneError, ReadRaw preserves such bytes exactly if r's source
+// implements io.RuneScanner and io.ByteReader (as bufio.Readers,
+// bytes.Readers, and strings.Readers do), so it can read binary fields
+// written by WriteRaw.  ReadRaw ignores r.OnInvalidUTF8.
+func (r *Reader) ReadRaw() (fields [][]byte, err error) {
+    r.passthrough = true
+    record, err := r.Read()
+    r.passthrough = false
+    for _, field := range record {
+        fields = append(fields, []byte(field))
+    }
+    return
+}
+
+// ReadAll reads all remaining records from r.  Each record is a slice of
+// fields, one string per field.  err is set to nil if no errors occur or
+// EOF is reached.  (EOF is not treated as an error.)
+//
+// If r.MaxRecords is positive and the input holds more than r.MaxRecords
+// records, ReadAll stops after reading r.MaxRecords records and returns them
+// along with ErrTooManyRecords.
+func (r *Reader) ReadAll() (records [][]string, err error) {
+    for {
+        record, err := r.Read()
+        if err == io.EOF {
+            return append(records, record), nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        if record == nil {
+            return records, nil
+        }
+        if r.MaxRecords > 0 && len(records) == r.MaxRecords {
+            return records, ErrTooManyRecords
+        }
+        records = append(records, record)
+    }
+}
+
+// ReadAllBytes reads all remaining records from r like ReadAll but returns
+// each field as a byte slice.  Every field gets its own newly allocated slice
+// that shares no memory with r or with other fields, so callers may modify
+// fields in place and keep them after later reads.  Unlike ReadRaw,
+// ReadAllBytes treats invalid UTF-8 as Read does.
+func (r *Reader) ReadAllBytes() (records [][][]byte, err error) {
+    text, err := r.ReadAll()
+    for _, record := range text {
+        fields := make([][]byte, len(record))
+        for n, field := range record {
+            fields[n] = []byte(field)
+        }
+        records = append(records, fields)
+    }
+    return
+}
+
+// Lint reads all remaining records from r and returns every problem that it
+// finds instead of stopping at the first: each *ParseError that Read returns
+// or reports to r.OnWarning, including dangling escape characters, wrong
+// field counts (if r.FieldsPerRecord is set), oversized fields (if
+// r.MaxFieldBytes is set), and NUL bytes (if r.RejectNUL is set).  After an
+// error within a record, Lint skips to the record's next unescaped record
+// separator and continues with the following record; errors found after a
+// whole record was read, such as invalid base64 fields, need no skipping.  Any
+// other error ends Lint, which returns it along with the problems found so
+// far.  OnWarning is still called for warnings.
+func (r *Reader) Lint() (problems []ParseError, err error) {
+    onWarning := r.OnWarning
+    defer func() {
+        r.OnWarning = onWarning
+    }()
+    r.OnWarning = func(warning error) {
+        if e, ok := warning.(*ParseError); ok {
+            problems = append(problems, *e)
+        }
+        if onWarning != nil {
+            onWarning(warning)
+        }
+    }
+    for {
+        record, err := r.Read()
+        if e, ok := err.(*ParseError); ok {
+            problems = append(problems, *e)
+            if r.midRecord {
+                if err = r.skipRecord(false); err != nil {
+                    return problems, err
+                }
+            }
+            continue
+        }
+        if err == io.EOF || err == nil && record == nil {
+            return problems, nil
+        }
+        if err != nil {
+            return problems, err
+        }
+    }
+}
+
+// skipRecord discards the rest of the current record, up to and including
+// the next unescaped record separator outside quotes, without storing any
+// fields and ignoring invalid UTF-8.  fieldStart reports whether r is at the
+// start of a field.  Readers using Split have already consumed the record.
+func (r *Reader) skipRecord(fieldStart bool) error {
+    if r.Split != nil {
+        return nil
+    }
+    for {
+        c, _, err := r.readRune()
+        if _, ok := err.(*ParseError); ok {
+            fieldStart = false
+            continue
+        }
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        switch {
+            case c == r.Separator:
+            case fieldStart && r.Quote != 0 && c == r.Quote:
+                if err = r.skipQuoted(); err != nil {
+                    return err
+                }
+            case r.isEscape(c):
+                if _, _, err = r.readRune(); err == io.EOF {
+                    return nil
+                }
+            case c == r.RecordSeparator:
+                return nil
+        }
+        fieldStart = c == r.Separator
+    }
+}
+
+// skipQuoted discards the rest of a quoted field for skipRecord, up to and
+// including its closing quote.
+func (r *Reader) skipQuoted() error {
+    for {
+        c, _, err := r.readRune()
+        if err == io.EOF {
+            return nil
+        }
+        if _, ok := err.(*ParseError); !ok && err != nil {
+            return err
+        }
+        if c != r.Quote {
+            continue
+        }
+        next, size, err := r.readRune()
+        if err == io.EOF {
+            return nil
+        }
+        if next != r.Quote {
+            r.unreadRunes([]pendingRune {{next, size, r.invalid, r.invalidByte, err}})
+            return nil
+        }
+    }
+}
+
+// ReadHeader reads the next record from r as a header naming the columns of
+// the records that follow it, for ReadMap.  If r.HeaderNormalize is set,
+// ReadMap names the columns with the results of applying it to the header's
+// names, as Decoder.HeaderNormalize does for struct fields, but ReadHeader
+// returns the names as read.  It returns io.EOF if no records remain.
+func (r *Reader) ReadHeader() (header []string, err error) {
+    if header, err = r.Read(); err != nil {
+        return nil, err
+    }
+    if header == nil {
+        return nil, io.EOF
+    }
+    r.header = header
+    if r.HeaderNormalize != nil {
+        r.header = make([]string, len(header))
+        for n, name := range header {
+            r.header[n] = r.HeaderNormalize(name)
+        }
+    }
+    return header, nil
+}
+
+// ReadMap reads one record from r and returns it as a map from the column
+// names in the header read by ReadHeader (normalized by r.HeaderNormalize if
+// it is set) to the record's fields.  Fields
+// beyond the header's columns are ignored, and columns beyond the record's
+// fields are absent from the map unless r.PadShortRecords is set, in which
+// case they map to empty fields.  If ReadHeader wasn't called, ReadMap
+// returns ErrNoHeader unless r.GenerateColumnNames is set, in which case each
+// field is named "col" followed by its index (counting from zero), as in
+// "col0" and "col1".  At the end of the input, ReadMap returns a nil map and
+// a nil error, as Read does.
+func (r *Reader) ReadMap() (map[string]string, error) {
+    if r.header == nil && !r.GenerateColumnNames {
+        return nil, ErrNoHeader
+    }
+    record, err := r.Read()
+    if record == nil {
+        return nil, err
+    }
+    if r.PadShortRecords && len(record) < len(r.header) {
+        record = append(record, make([]string, len(r.header) - len(record))...)
+    }
+    m := make(map[string]string, len(record))
+    for n, field := range record {
+        switch {
+            case r.header == nil:
+                m["col" + strconv.Itoa(n)] = field
+            case n < len(r.header):
+                m[r.header[n]] = field
+        }
+    }
+    return m, err
+}
+
+// ReadSchemaHeader reads a schema header written by Writer.WriteSchemaHeader
+// from the start of r's input and configures r's Separator and Escape from
+// it, returning the resulting dialect.  If the input doesn't begin with '#',
+// ReadSchemaHeader consumes nothing and returns ErrNoSchemaHeader, so callers
+// can fall back to r's existing settings.  Unknown header settings are
+// ignored.
+func (r *Reader) ReadSchemaHeader() (Dialect, error) {
+    var line strings.Builder
+
+    c, size, err := r.readRune()
+    if err == io.EOF {
+        return r.Dialect(), ErrNoSchemaHeader
+    }
+    if err != nil {
+        return r.Dialect(), err
+    }
+    if c != '#' {
+        r.unreadRunes([]pendingRune {{c, size, r.invalid, r.invalidByte, nil}})
+        return r.Dialect(), ErrNoSchemaHeader
+    }
+    for {
+        if c, _, err = r.readRune(); err == io.EOF || (err == nil && c == r.RecordSeparator) {
+            break
+        }
+        if err != nil {
+            return r.Dialect(), err
+        }
+        line.WriteRune(c)
+    }
+    words := strings.Fields(line.String())
+    if len(words) < 2 || words[0] != "dsv" || words[1] != "v1" {
+        return r.Dialect(), ErrSchemaHeader
+    }
+    d := r.Dialect()
+    for _, word := range words[2:] {
+        key, value, _ := strings.Cut(word, "=")
+        c, ok := parseSchemaRune(value)
+        switch key {
+            case "sep":
+                d.Separator = c
+            case "esc":
+                d.Escape = c
+                d.EscapeString = ""
+            default:
+                continue
+        }
+        if !ok {
+            return r.Dialect(), ErrSchemaHeader
+        }
+    }
+    if err = d.Validate(); err != nil {
+        return r.Dialect(), err
+    }
+    r.SetDialect(d)
+    return d, nil
+}
+
+// schemaRune returns c as it appears in a schema header: as itself if it's a
+// graphic, non-space character and in the form "U+0009" otherwise.
+func schemaRune(c rune) string {
+    if unicode.IsGraphic(c) && !unicode.IsSpace(c) {
+        return string(c)
+    }
+    hex := strings.ToUpper(strconv.FormatInt(int64(c), 16))
+    for len(hex) < 4 {
+        hex = "0" + hex
+    }
+    return "U+" + hex
+}
+
+// parseSchemaRune is the inverse of schemaRune.
+func parseSchemaRune(s string) (rune, bool) {
+    if len(s) > 2 && strings.HasPrefix(s, "U+") {
+        n, err := strconv.ParseUint(s[2:], 16, 32)
+        return rune(n), err == nil
+    }
+    c, size := utf8.DecodeRuneInString(s)
+    return c, c != utf8.RuneError && size == len(s)
+}
+
+// ReadLineNumber reads forward to the nth record of r's input (counting from
+// 1, as ParseErrors do) and returns it, so that a record that a report
+// identifies by number can be examined.  Records are read and discarded
+// until the nth is reached, and an error reading any of them is returned
+// immediately.  ReadLineNumber returns ErrRecordNumber if r has already read
+// the nth record or the input has fewer than n records.
+func (r *Reader) ReadLineNumber(n int) ([]string, error) {
+    if n < 1 || int64(n) <= r.records {
+        return nil, ErrRecordNumber
+    }
+    for {
+        record, err := r.Read()
+        if err != nil {
+            return record, err
+        }
+        if record == nil {
+            return nil, ErrRecordNumber
+        }
+        if r.records >= int64(n) {
+            return record, nil
+        }
+    }
+}
+
+// ReadUntil reads records from r until isEnd reports that a record ends the
+// current section or r reaches the end of its input.  The record that ends the
+// section is consumed but not returned, so later calls to Read or ReadUntil
+// continue with the records that follow it.  As with ReadAll, EOF is not
+// treated as an error.
+func (r *Reader) ReadUntil(isEnd func([]string) bool) (records [][]string, err error) {
+    for {
+        record, err := r.Read()
+        if err != nil {
+            return nil, err
+        }
+        if record == nil || isEnd(record) {
+            return records, nil
+        }
+        records = append(records, record)
+    }
+}
+
+// Channel starts a goroutine that reads records from r and sends them on the
+// returned record channel.  Both returned channels are closed when r reaches
+// the end of its input, when Read returns an error, or when ctx is done.  In
+// the latter two cases, the error (or ctx.Err()) is sent on the error channel
+// before it is closed.  The goroutine exits promptly when ctx is done unless
+// it is blocked reading r's source.  r must not be used while the goroutine
+// is running.
+func (r *Reader) Channel(ctx context.Context) (<-chan []string, <-chan error) {
+    records := make(chan []string)
+    errs := make(chan error, 1)
+    go func() {
+        defer close(errs)
+        defer close(records)
+        for {
+            if err := ctx.Err(); err != nil {
+                errs <- err
+                return
+            }
+            record, err := r.Read()
+            if err != nil {
+                errs <- err
+                return
+            }
+            if record == nil {
+                return
+            }
+            select {
+                case records <- record:
+                case <-ctx.Done():
+                    errs <- ctx.Err()
+                    return
+            }
+        }
+    }()
+    return records, errs
+}
+
+// NewWriter returns a Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+    closer, _ := w.(io.Closer)
+    return &Writer {
+        Escape:          '\\',
+        Separator:       ':',
+        RecordSeparator: '\n',
+        writer:          bufio.NewWriter(w),
+        closer:          closer,
+    }
+}
+
+// NewTSVWriter returns a Writer that writes tab-separated values to w in the
+// dialect that NewTSVReader reads, escaping tabs, newlines, and reverse
+// solidi within fields.
+func NewTSVWriter(w io.Writer) *Writer {
+    writer := NewWriter(w)
+    writer.SetDialect(tsvDialect)
+    return writer
+}
+
+// OpenAppendWriter opens the named file for appending, creating it if
+// necessary, and returns a Writer that writes records in dialect d to the end
+// of it.  If the file's existing content doesn't end with d.RecordSeparator,
+// the Writer first buffers one so that new records don't merge with a final
+// record that lacks its separator.  Closing the Writer closes the file.
+func OpenAppendWriter(path string, d Dialect) (*Writer, error) {
+    if err := d.Validate(); err != nil {
+        return nil, err
+    }
+    f, err := os.OpenFile(path, os.O_RDWR | os.O_APPEND | os.O_CREATE, 0666)
+    if err != nil {
+        return nil, err
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    writer := NewWriter(f)
+    writer.SetDialect(d)
+    if n := int64(utf8.RuneLen(d.RecordSeparator)); info.Size() > 0 {
+        last := make([]byte, n)
+        if info.Size() < n {
+            last = last[:info.Size()]
+        }
+        if _, err = f.ReadAt(last, info.Size() - int64(len(last))); err != nil {
+            f.Close()
+            return nil, err
+        }
+        if r, _ := utf8.DecodeRune(last); r != d.RecordSeparator {
+            writer.writer.WriteRune(d.RecordSeparator)
+        }
+    }
+    return writer, nil
+}
+
+// NewWriterGzip returns a Writer that writes gzip-compressed DSV data to w
+// along with a function that flushes the Writer and then closes the gzip
+// stream.  The function must be called after the last record is written; it
+// does not close w.
+func NewWriterGzip(w io.Writer) (*Writer, func() error) {
+    gz := gzip.NewWriter(w)
+    writer := NewWriter(gz)
+    return writer, func() error {
+        if err := writer.flush(); err != nil {
+            gz.Close()
+            return err
+        }
+        return gz.Close()
+    }
+}
+
+// Close flushes w's buffered data and then closes w's underlying io.Writer if
+// it implements io.Closer.  The underlying io.Writer is closed even if
+// flushing fails, but the flush error takes precedence over any error from
+// closing.
+func (w *Writer) Close() error {
+    err := w.flush()
+    if w.closer != nil {
+        if closeErr := w.closer.Close(); err == nil {
+            err = closeErr
+        }
+    }
+    return err
+}
+
+// Dialect returns w's current dialect settings.
+func (w *Writer) Dialect() Dialect {
+    return Dialect {
+        Escape:          w.Escape,
+        EscapeString:    w.EscapeString,
+        Separator:       w.Separator,
+        RecordSeparator: w.RecordSeparator,
+        Quote:           w.Quote,
+        Comment:         w.Comment,
+    }
+}
+
+// SetDialect changes w's dialect settings to those of d.
+func (w *Writer) SetDialect(d Dialect) {
+    w.Escape = d.Escape
+    w.EscapeString = d.EscapeString
+    w.Separator = d.Separator
+    w.RecordSeparator = d.RecordSeparator
+    w.Quote = d.Quote
+    w.Comment = d.Comment
+}
+
+// Validate reports whether w's settings are valid.  See Dialect.Validate.
+// Validate also returns ErrNoEscape if w has no escape character but
+// w.EscapeNonASCII is set or, unless w.Quote is set, w protects runes with
+// ProtectRune.
+func (w *Writer) Validate() error {
+    d := w.Dialect()
+    if err := d.Validate(); err != nil {
+        return err
+    }
+    if d.escapeRune() == 0 && (w.EscapeNonASCII || w.Quote == 0 && len(w.protected) > 0) {
+        return ErrNoEscape
+    }
+    return nil
+}
+
+// ProtectRune makes w escape every occurrence of r within fields.  This
+// protects delimiters of formats nested within fields (such as '|' for
+// fields that a second parser splits on '|') from being mistaken for the
+// nested format's delimiters when the fields are read back as raw text.
+// Readers unescape every escaped character, so they decode protected
+// characters without any configuration.
+func (w *Writer) ProtectRune(r rune) {
+    if w.protected == nil {
+        w.protected = make(map[rune]bool)
+    }
+    w.protected[r] = true
+}
+
+// VerbatimField makes w write the field at index as is, without escaping, in
+// records where it is the last field.  Readers with a SplitLimit of index+1
+// and VerbatimLastField set read such fields back unchanged, so the field can
+// hold free text with separators and escape characters.  Write returns a
+// *WriteError wrapping ErrVerbatimField for a verbatim field that contains
+// the record separator.
+func (w *Writer) VerbatimField(index int) {
+    if w.verbatim == nil {
+        w.verbatim = make(map[int]bool)
+    }
+    w.verbatim[index] = true
+}
+
+// AutoFlushEvery makes w flush its buffered output to the underlying
+// io.Writer after every n records that it writes, bounding the latency of a
+// long-running Writer without flushing each record.  An n of zero or less
+// disables automatic flushing, which is the default.
+func (w *Writer) AutoFlushEvery(n int) {
+    w.flushEvery = n
+}
+
+// AutoFlushInterval makes w flush its buffered output to the underlying
+// io.Writer whenever it writes a record and at least d has passed since its
+// last flush, so records don't sit in the buffer indefinitely during quiet
+// periods.  The elapsed time is checked only when a record is written; w
+// starts no goroutines.  A d of zero or less disables time-based flushing,
+// which is the default.
+func (w *Writer) AutoFlushInterval(d time.Duration) {
+    if w.now == nil {
+        w.now = time.Now
+    }
+    w.flushInterval = d
+    w.lastFlush = w.now()
+}
+
+// DedupByField makes w skip each record whose field at index equals that of
+// the record that w wrote before it, so that sorted input is written without
+// consecutive duplicate keys.  Only adjacent records are compared, and w
+// remembers only the last key, so duplicates separated by other keys are all
+// written.  Records without a field at index are always written and end any
+// run of duplicates.
+func (w *Writer) DedupByField(index int) {
+    w.dedup = true
+    w.dedupIndex = index
+    w.hasLastKey = false
+}
+
+// Error reports any error that occurred during the last Flush or Write.
+func (w *Writer) Error() error {
+    _, err := w.writer.Write(nil)
+    return err
+}
+
+// Flush writes buffered data to w's underlying io.Writer.  Call Error to
+// check for errors.
+func (w *Writer) Flush() {
+    w.flush()
+}
+
+// flush flushes w's buffer and, if w flushes periodically, records the time.
+func (w *Writer) flush() error {
+    if w.flushInterval > 0 {
+        w.lastFlush = w.now()
+    }
+    return w.writer.Flush()
+}
+
+// Write writes a single record to w.  The record is a slice of strings
+// representing its fields, one string per field.  Characters within the
+// fields are escaped as necessary.  Fields may contain any characters,
+// including any number of record separators (such as the newlines in a
+// multiline note); a Reader with the same settings decodes them unchanged.
+//
+// A record with no fields (including a nil record) and a record with a single
+// empty field are both written as an empty line, so Readers can't
+// distinguish them.  Readers skip empty lines unless PreserveEmptyRecords is
+// set, in which case they read each one as a record with a single empty
+// field.  Writers with QuoteEmptyFields set distinguish them.
+func (w *Writer) Write(record []string) error {
+    return w.writeRecord(record, false)
+}
+
+// WriteRaw writes a single record to w like Write but takes its fields as
+// byte slices, which may hold arbitrary binary data.  Unlike Write, which
+// writes invalid UTF-8 bytes as utf8.RuneError, WriteRaw writes such bytes
+// as is.  Use Reader.ReadRaw to read them back.
+func (w *Writer) WriteRaw(record [][]byte) error {
+    fields := make([]string, len(record))
+    for n, field := range record {
+        fields[n] = string(field)
+    }
+    return w.writeRecord(fields, true)
+}
+
+// writeRecord writes a single record to w.  If raw is set, invalid UTF-8
+// bytes are written as is.
+func (w *Writer) writeRecord(record []string, raw bool) (err error) {
+    if err = w.Validate(); err != nil {
+        return
+    }
+    w.recordsAttempted++
+    if w.SplitLimit > 0 && len(record) > w.SplitLimit {
+        return &WriteError {
+            Record: w.recordsAttempted,
+            Field:  w.SplitLimit + 1,
+            Err:    ErrTooManyFields,
+        }
+    }
+    if len(record) == 0 && w.QuoteEmptyFields && w.Quote != 0 && !w.LengthPrefixed {
+        return nil
+    }
+    var key string
+    hasKey := w.dedup && w.dedupIndex >= 0 && w.dedupIndex < len(record)
+    if hasKey {
+        key = record[w.dedupIndex]
+        if w.hasLastKey && key == w.lastKey {
+            return nil
+        }
+    }
+    defer func() {
+        if err != nil {
+            return
+        }
+        w.lastKey, w.hasLastKey = key, hasKey
+        w.recordsWritten++
+        if w.flushEvery > 0 && w.recordsWritten % w.flushEvery == 0 ||
+                w.flushInterval > 0 && w.now().Sub(w.lastFlush) >= w.flushInterval {
+            err = w.flush()
+        }
+    }()
+    if w.Base64Fields != nil {
+        encoded := make([]string, len(record))
+        for n, field := range record {
+            encoded[n] = field
+            if w.Base64Fields(n) {
+                encoded[n] = base64.StdEncoding.EncodeToString([]byte(field))
+            }
+        }
+        record = encoded
+    }
+    if w.NormalizeFieldNewlines {
+        normalized := make([]string, len(record))
+        for n, field := range record {
+            normalized[n] = strings.Replace(strings.Replace(field, "\r\n", "\n", -1), "\r", "\n", -1)
+        }
+        record = normalized
+    }
+    if w.RejectNUL {
+        for n, field := range record {
+            if strings.IndexByte(field, 0) >= 0 {
+                return &WriteError {
+                    Record: w.recordsAttempted,
+                    Field:  n + 1,
+                    Err:    ErrNUL,
+                }
+            }
+        }
+    }
+    if w.RawFieldMarker != 0 && w.MarkRawFields != nil {
+        for n, field := range record {
+            if w.MarkRawFields(n) && strings.ContainsAny(field, string([]rune {w.Separator, w.RecordSeparator})) {
+                return &WriteError {
+                    Record: w.recordsAttempted,
+                    Field:  n + 1,
+                    Err:    ErrRawField,
+                }
+            }
+        }
+    }
+    last := len(record) - 1
+    if w.Dialect().escapeRune() == 0 && w.Quote == 0 && !w.LengthPrefixed {
+        for n, field := range record {
+            if w.needsEscape(field, n, last) {
+                return &WriteError {
+                    Record: w.recordsAttempted,
+                    Field:  n + 1,
+                    Err:    ErrNoEscape,
+                }
+            }
+        }
+    }
+    if w.verbatim[last] && strings.ContainsRune(record[last], w.RecordSeparator) {
+        return &WriteError {
+            Record: w.recordsAttempted,
+            Field:  last + 1,
+            Err:    ErrVerbatimField,
+        }
+    }
+    if w.WindowsLineEndings && w.unterminated {
+        if _, err = w.writer.WriteString("\r\n"); err != nil {
+            return
+        }
+    }
+    for n, field := range record {
+        if w.LengthPrefixed {
+            if err = w.writeLengthPrefixedField(field); err != nil {
+                return
+            }
+            continue
+        }
+        if n > 0 {
+            if _, err = w.writer.WriteRune(w.Separator); err != nil {
+                return
+            }
+        }
+        if n == last && w.verbatim[n] {
+            _, err = w.writer.WriteString(field)
+        } else if w.AlwaysQuote && w.Quote != 0 || field == "" && w.QuoteEmptyFields && w.Quote != 0 {
+            err = w.writeQuotedField(field, raw)
+        } else if w.RawFieldMarker != 0 && w.MarkRawFields != nil && w.MarkRawFields(n) {
+            if _, err = w.writer.WriteRune(w.RawFieldMarker); err == nil {
+                _, err = w.writer.WriteString(field)
+            }
+        } else if c, size := utf8.DecodeRuneInString(field); size > 0 &&
+                (n == 0 && w.Comment != 0 && c == w.Comment || w.RawFieldMarker != 0 && c == w.RawFieldMarker) {
+            if w.Dialect().escapeRune() == 0 && w.Quote != 0 {
+                err = w.writeQuotedField(field, raw)
+            } else if err = w.writeEscape(); err == nil {
+                if _, err = w.writer.WriteRune(c); err == nil {
+                    err = w.writeField(field[size:], raw, n == w.SplitLimit - 1)
+                }
+            }
+        } else {
+            err = w.writeField(field, raw, n == w.SplitLimit - 1)
+        }
+        if err != nil {
+            return
+        }
+    }
+    if w.WindowsLineEndings {
+        w.unterminated = true
+        return
+    }
+    _, err = w.writer.WriteRune(w.RecordSeparator)
+    return
+}
+
+// needsEscape reports whether writeRecord must write an escape character
+// within field, the field at index n of a record whose last field is at
+// index last.
+func (w *Writer) needsEscape(field string, n, last int) bool {
+    if n == last && w.verbatim[n] || w.RawFieldMarker != 0 && w.MarkRawFields != nil && w.MarkRawFields(n) {
+        return false
+    }
+    if c, size := utf8.DecodeRuneInString(field); size > 0 &&
+            (n == 0 && w.Comment != 0 && c == w.Comment || w.RawFieldMarker != 0 && c == w.RawFieldMarker) {
+        return true
+    }
+    for _, r := range field {
+        if r == w.Separator && n != w.SplitLimit - 1 || r == w.RecordSeparator || w.protected[r] ||
+                w.WindowsLineEndings && (r == '\r' || r == '\n') {
+            return true
+        }
+    }
+    return false
+}
+
+// writeRune writes r, the rune at index i of field, to w.  If raw is set and
+// r is an invalid UTF-8 byte, the byte is written as is.
+func (w *Writer) writeRune(field string, i int, r rune, raw bool) (err error) {
+    if raw && r == utf8.RuneError {
+        if _, size := utf8.DecodeRuneInString(field[i:]); size == 1 {
+            return w.writer.WriteByte(field[i])
+        }
+    }
+    _, err = w.writer.WriteRune(r)
+    return
+}
+
+// writeField writes a single field to w, escaping characters as necessary.
+// A leading Quote character is escaped so that Readers don't take the field
+// for a quoted one.  If w has no escape character, fields that need escaping
+// (or begin with Quote) are quoted instead.  Separators are written unescaped if literalSeparators is set.
+// See writeRune for raw.  Fields without special characters, which are the
+// common case, are written with a single WriteString.
+func (w *Writer) writeField(field string, raw, literalSeparators bool) (err error) {
+    escape := w.Dialect().escapeRune()
+    if escape == 0 && w.Quote != 0 && (strings.HasPrefix(field, string(w.Quote)) ||
+            w.needsEscaping(field, escape, raw, literalSeparators)) {
+        return w.writeQuotedField(field, raw)
+    }
+    if c, size := utf8.DecodeRuneInString(field); escape != 0 && w.Quote != 0 && size > 0 && c == w.Quote {
+        if err = w.writeEscape(); err == nil {
+            if _, err = w.writer.WriteRune(c); err == nil {
+                err = w.writeField(field[size:], raw, literalSeparators)
+            }
+        }
+        return
+    }
+    if !w.needsEscaping(field, escape, raw, literalSeparators) {
+        _, err = w.writer.WriteString(field)
+        return
+    }
+    return w.writeEscapedField(field, escape, raw, literalSeparators)
+}
+
+// needsEscaping reports whether writeField must write field rune by rune,
+// that is, whether field contains any rune that w escapes or replaces.
+func (w *Writer) needsEscaping(field string, escape rune, raw, literalSeparators bool) bool {
+    if !raw && !utf8.ValidString(field) {
+        return true
+    }
+    for _, r := range field {
+        if w.EscapeNonASCII && r >= utf8.RuneSelf || r == w.Separator && !literalSeparators ||
+                r == escape && escape != 0 || r == w.RecordSeparator || w.protected[r] ||
+                w.WindowsLineEndings && (r == '\r' || r == '\n') {
+            return true
+        }
+    }
+    return false
+}
+
+// writeEscapedField writes field to w rune by rune, escaping characters as
+// necessary.  See writeField for the other arguments.
+func (w *Writer) writeEscapedField(field string, escape rune, raw, literalSeparators bool) (err error) {
+    for i, r := range field {
+        switch {
+            case w.EscapeNonASCII && r >= utf8.RuneSelf:
+                err = w.writeUnicodeEscape(r)
+            case r == w.Separator && literalSeparators:
+                _, err = w.writer.WriteRune(r)
+            case r == escape && escape != 0, r == w.Separator, r == w.RecordSeparator,
+                    w.WindowsLineEndings && (r == '\r' || r == '\n'):
+                if err = w.writeEscape(); err == nil {
+                    _, err = w.writer.WriteRune(r)
+                }
+            default:
+                if w.protected[r] {
+                    if err = w.writeEscape(); err != nil {
+                        return
+                    }
+                }
+                err = w.writeRune(field, i, r, raw)
+        }
+        if err != nil {
+            return
+        }
+    }
+    return
+}
+
+// writeLengthPrefixedField writes field's length, a separator, and then field
+// as is.
+func (w *Writer) writeLengthPrefixedField(field string) (err error) {
+    if _, err = w.writer.WriteString(strconv.Itoa(len(field))); err != nil {
+        return
+    }
+    if _, err = w.writer.WriteRune(w.Separator); err != nil {
+        return
+    }
+    _, err = w.writer.WriteString(field)
+    return
+}
+
+// writeUnicodeEscape writes r as a Unicode escape sequence.
+func (w *Writer) writeUnicodeEscape(r rune) (err error) {
+    if err = w.writeEscape(); err != nil {
+        return
+    }
+    kind, digits := 'u', 4
+    if r > 0xFFFF {
+        kind, digits = 'U', 8
+    }
+    hex := strconv.FormatInt(int64(r), 16)
+    _, err = w.writer.WriteString(string(kind) + strings.Repeat("0", digits - len(hex)) + strings.ToUpper(hex))
+    return
+}
+
+// writeEscape writes w's escape prefix.  It returns ErrNoEscape if w has no
+// escape character.
+func (w *Writer) writeEscape() (err error) {
+    if w.Dialect().escapeRune() == 0 {
+        return ErrNoEscape
+    }
+    if w.EscapeString != "" {
+        _, err = w.writer.WriteString(w.EscapeString)
+    } else {
+        _, err = w.writer.WriteRune(w.Escape)
+    }
+    return
+}
+
+// writeQuotedField writes a single field to w enclosed in Quote characters.
+// Quote characters within the field are doubled; no other characters are
+// escaped.  See writeRune for raw.
+func (w *Writer) writeQuotedField(field string, raw bool) (err error) {
+    if _, err = w.writer.WriteRune(w.Quote); err != nil {
+        return
+    }
+    for i, r := range field {
+        if r == w.Quote {
+            if _, err = w.writer.WriteRune(w.Quote); err != nil {
+                return
+            }
+        }
+        if err = w.writeRune(field, i, r, raw); err != nil {
+            return
+        }
+    }
+    _, err = w.writer.WriteRune(w.Quote)
+    return
+}
+
+// WriteWithSeparator writes a single record to w like Write but separates and
+// escapes its fields with sep instead of w.Separator, for multiplexed formats
+// in which each type of record has its own separator.  It returns
+// ErrRecordSeparatorConflict if sep is w's record separator and
+// ErrSeparatorConflict if sep is w's escape character.
+//
+// Readers use a single Separator for all records, so reading such a stream
+// requires knowing each record's separator before parsing it: for example,
+// when records hold no escaped record separators, by splitting the stream
+// into records and parsing each with ParseRecord using the separator that its
+// type implies.
+func (w *Writer) WriteWithSeparator(record []string, sep rune) error {
+    separator := w.Separator
+    w.Separator = sep
+    defer func() {
+        w.Separator = separator
+    }()
+    return w.Write(record)
+}
+
+// WriteFlush writes a single record to w and flushes w's buffered data to the
+// underlying io.Writer.  It returns the first error encountered while writing
+// or flushing.
+func (w *Writer) WriteFlush(record []string) error {
+    if err := w.Write(record); err != nil {
+        return err
+    }
+    return w.flush()
+}
+
+// WriteChannel writes each record received from records to w until records
+// is closed or ctx is done and then flushes w.  It returns the first error
+// encountered while writing or flushing or, if ctx is done first, ctx.Err().
+// WriteChannel stops receiving records after an error.
+func (w *Writer) WriteChannel(ctx context.Context, records <-chan []string) (err error) {
+    for err == nil {
+        select {
+            case record, ok := <-records:
+                if !ok {
+                    return w.flush()
+                }
+                err = w.Write(record)
+            case <-ctx.Done():
+                err = ctx.Err()
+        }
+    }
+    w.flush()
+    return
+}
+
+// WriteHeader writes header to w as a record unless w has already written a
+// header, in which case WriteHeader does nothing and returns nil.
+func (w *Writer) WriteHeader(header []string) (err error) {
+    if w.headerWritten {
+        return
+    }
+    if err = w.Write(header); err == nil {
+        w.headerWritten = true
+    }
+    return
+}
+
+// WriteSchemaHeader writes a line such as "#dsv v1 sep=: esc=\" to w that
+// describes w's Separator and Escape so that Reader.ReadSchemaHeader can
+// configure a Reader to read w's output.  It must be called before w writes
+// any records.  Settings that a schema header can't describe, such as
+// EscapeString, aren't written.  Readers whose Comment is '#' skip the
+// header as a comment.
+func (w *Writer) WriteSchemaHeader() error {
+    if err := w.Validate(); err != nil {
+        return err
+    }
+    header := "#dsv v1 sep=" + schemaRune(w.Separator)
+    if w.Escape != 0 && w.EscapeString == "" {
+        header += " esc=" + schemaRune(w.Escape)
+    }
+    if _, err := w.writer.WriteString(header); err != nil {
+        return err
+    }
+    _, err := w.writer.WriteRune(w.RecordSeparator)
+    return err
+}
+
+// WriteOrderedMap writes the values of m as a record whose fields are in the
+// order of keys, writing an empty field for each key absent from m.  Keys of
+// m that aren't in keys are ignored.
+func (w *Writer) WriteOrderedMap(keys []string, m map[string]string) error {
+    record := make([]string, len(keys))
+    for i, key := range keys {
+        record[i] = m[key]
+    }
+    return w.Write(record)
+}
+
+// SetHeaderWritten sets whether w treats its header as already written.
+// Writers that append to output that already begins with a header should
+// call SetHeaderWritten(true) so that WriteHeader doesn't repeat it.
+func (w *Writer) SetHeaderWritten(written bool) {
+    w.headerWritten = written
+}
+
+// WriteRecords writes multiple records to w without flushing it, so that
+// output assembled from several calls can be flushed once at the end.  It
+// stops at the first error, which it returns as is, unless w.ContinueOnError
+// is set.
+func (w *Writer) WriteRecords(records [][]string) (err error) {
+    var errs []error
+    for _, record := range records {
+        if err = w.Write(record); err != nil {
+            if !w.ContinueOnError {
+                return
+            }
+            errs = append(errs, err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// WriteAll writes multiple records to w and calls Flush.  If
+// w.ContinueOnError is set, w is flushed even if some records fail.
+func (w *Writer) WriteAll(records [][]string) (err error) {
+    err = w.WriteRecords(records)
+    if !w.ContinueOnError {
+        if err != nil {
+            return
+        }
+        return w.flush()
+    }
+    if flushErr := w.flush(); flushErr != nil {
+        return errors.Join(err, flushErr)
+    }
+    return
+}
+
+// WriteSorted writes records to w in the order determined by less and calls
+// Flush.  The sort is stable and sorts a copy of records, so the caller's
+// slice keeps its order.
+func (w *Writer) WriteSorted(records [][]string, less func(a, b []string) bool) error {
+    sorted := append([][]string(nil), records...)
+    sort.SliceStable(sorted, func(i, j int) bool {
+        return less(sorted[i], sorted[j])
+    })
+    return w.WriteAll(sorted)
+}
+
+// Copy reads records from src and writes them to dst until src is exhausted
+// and then flushes dst.  It returns the number of records copied and the
+// first error encountered while reading, writing, or flushing.
+func Copy(dst *Writer, src *Reader) (records int64, err error) {
+    for {
+        var record []string
+        if record, err = src.Read(); err != nil {
+            return
+        }
+        if record == nil {
+            break
+        }
+        if err = dst.Write(record); err != nil {
+            return
+        }
+        records++
+    }
+    err = dst.flush()
+    return
+}
+
+// TransformStream reads records from src, passes each to fn, and writes the
+// zero or more records that fn returns to dst in order until src is
+// exhausted, and then flushes dst.  It returns the first error encountered
+// while reading, transforming, writing, or flushing; an error from fn stops
+// the stream without flushing dst.
+func TransformStream(dst *Writer, src *Reader, fn func([]string) ([][]string, error)) error {
+    for {
+        record, err := src.Read()
+        if err != nil {
+            return err
+        }
+        if record == nil {
+            return dst.flush()
+        }
+        records, err := fn(record)
+        if err != nil {
+            return err
+        }
+        for _, out := range records {
+            if err = dst.Write(out); err != nil {
+                return err
+            }
+        }
+    }
+}
+
+// MergeSorted reads records from a and b, which must each be sorted
+// according to less, and writes their merge to dst in sorted order, flushing
+// dst at the end.  Records that compare equal are written from a before b.
+// MergeSorted holds at most one record from each Reader at a time, so the
+// inputs may be arbitrarily large; when one input is exhausted, the rest of
+// the other is copied.  It returns the first error encountered while reading,
+// writing, or flushing.
+func MergeSorted(dst *Writer, a, b *Reader, less func(x, y []string) bool) error {
+    x, err := a.Read()
+    if err != nil {
+        return err
+    }
+    y, err := b.Read()
+    if err != nil {
+        return err
+    }
+    for x != nil && y != nil {
+        if less(y, x) {
+            if err = dst.Write(y); err == nil {
+                y, err = b.Read()
+            }
+        } else if err = dst.Write(x); err == nil {
+            x, err = a.Read()
+        }
+        if err != nil {
+            return err
+        }
+    }
+    rest, src := x, a
+    if y != nil {
+        rest, src = y, b
+    }
+    if rest != nil {
+        if err = dst.Write(rest); err != nil {
+            return err
+        }
+        if _, err = Copy(dst, src); err != nil {
+            return err
+        }
+    }
+    return dst.flush()
+}
+
+// Transcode reads records from r in the from dialect and writes them to w in
+// the to dialect, escaping characters as the to dialect requires.  It returns
+// the number of records transcoded.  See Copy.
+//
+// The dialects' escape characters are independent: fields are fully
+// unescaped with from's escape character and then re-escaped minimally for
+// to, so the from escape character is an ordinary character in the output
+// and occurrences of the to escape character in field content are escaped.
+func Transcode(w io.Writer, r io.Reader, from, to Dialect) (int64, error) {
+    reader := NewReader(runeReader(r))
+    reader.SetDialect(from)
+    writer := NewWriter(w)
+    writer.SetDialect(to)
+    return Copy(writer, reader)
+}
+
+// ReadColumns reads all records from r using the settings in d and returns
+// their fields transposed into columns, so that columns[i][j] is field i of
+// record j.  If hasHeader is set, the first record is returned as header
+// instead of in columns.  Records with different numbers of fields make
+// ReadColumns return a *ParseError wrapping ErrFieldCount, as with
+// FieldsPerRecord, unless pad is set, in which case every column is padded
+// with empty fields to the number of records, whatever the width of the
+// widest record.  The columns read before an error are returned with it.
+func ReadColumns(r io.Reader, d Dialect, hasHeader, pad bool) (columns [][]string, header []string, err error) {
+    reader := NewReader(runeReader(r))
+    reader.SetDialect(d)
+    if hasHeader {
+        if header, err = reader.ReadHeader(); err != nil {
+            if err == io.EOF {
+                err = nil
+            }
+            return
+        }
+        if !pad {
+            reader.FieldsPerRecord = len(header)
+        }
+    }
+    for records := 0; ; records++ {
+        var record []string
+        if record, err = reader.Read(); record == nil || err != nil {
+            return
+        }
+        if !pad && reader.FieldsPerRecord == 0 {
+            reader.FieldsPerRecord = len(record)
+        }
+        for len(columns) < len(record) {
+            columns = append(columns, make([]string, records))
+        }
+        for n := range columns {
+            field := ""
+            if n < len(record) {
+                field = record[n]
+            }
+            columns[n] = append(columns[n], field)
+        }
+    }
+}
+
+// WriteColumns writes the column-oriented data in columns to w in dialect d
+// as records, the inverse of ReadColumns: record j consists of field j of
+// each column.  If header is non-nil, it is written as the first record.
+// WriteColumns returns ErrColumnLength without writing anything if the
+// columns have unequal lengths.
+func WriteColumns(w io.Writer, columns [][]string, header []string, d Dialect) error {
+    for _, column := range columns {
+        if len(column) != len(columns[0]) {
+            return ErrColumnLength
+        }
+    }
+    writer := NewWriter(w)
+    writer.SetDialect(d)
+    if header != nil {
+        if err := writer.WriteHeader(header); err != nil {
+            return err
+        }
+    }
+    if len(columns) > 0 {
+        record := make([]string, len(columns))
+        for j := range columns[0] {
+            for i, column := range columns {
+                record[i] = column[j]
+            }
+            if err := writer.Write(record); err != nil {
+                return err
+            }
+        }
+    }
+    return writer.flush()
+}
+
+// Lint reads all records from r using the settings in d and returns every
+// problem that it finds, as Reader.Lint does.
+func Lint(r io.Reader, d Dialect) ([]ParseError, error) {
+    reader := NewReader(runeReader(r))
+    reader.SetDialect(d)
+    return reader.Lint()
+}
+
+// Canonicalize reads records from r and rewrites them to w in the same
+// dialect d with minimal escaping: producers may escape any character, but
+// Writers escape only d's escape, separator, and record separator characters.
+// Inputs with the same records thus canonicalize to the same output.
+func Canonicalize(w io.Writer, r io.Reader, d Dialect) error {
+    _, err := Transcode(w, r, d, d)
+    return err
+}
+
+// ParseRecord parses a single record from s using the settings in d.  It
+// returns ErrMultipleRecords if s contains more than one record and nil
+// fields if s contains no records.
+func ParseRecord(s string, d Dialect) (fields []string, err error) {
+    reader := NewReader(strings.NewReader(s))
+    reader.SetDialect(d)
+    if fields, err = reader.Read(); err != nil || fields == nil {
+        return
+    }
+    next, err := reader.Read()
+    if err != nil {
+        return nil, err
+    }
+    if next != nil {
+        return nil, ErrMultipleRecords
+    }
+    return
+}
+
+// EncodeRecord returns record encoded with the settings in d but without the
+// terminating record separator, as the inverse of ParseRecord.  The result can
+// be used as a field of an outer record to nest records.
+func EncodeRecord(record []string, d Dialect) (string, error) {
+    var builder strings.Builder
+    writer := NewWriter(&builder)
+    writer.SetDialect(d)
+    if err := writer.Write(record); err != nil {
+        return "", err
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        return "", err
+    }
+    return strings.TrimSuffix(builder.String(), string(d.RecordSeparator)), nil
+}
+
+// EncodedLen returns the number of bytes that a Writer with the settings in d
+// would write for record, including escape characters, quotes, field
+// separators, and the terminating record separator.  If such a Writer would
+// reject record with ErrNoEscape, EncodedLen returns -1.
+func EncodedLen(record []string, d Dialect) (n int) {
+    for i, field := range record {
+        if i > 0 {
+            n += utf8.RuneLen(d.Separator)
+        }
+        length := encodedFieldLen(field, d)
+        if c, size := utf8.DecodeRuneInString(field); i == 0 && d.Comment != 0 && size > 0 && c == d.Comment {
+            if d.escapeRune() != 0 {
+                length = d.escapeLen() + size + encodedFieldLen(field[size:], d)
+            } else if d.Quote != 0 {
+                length = quotedFieldLen(field, d.Quote)
+            } else {
+                length = -1
+            }
+        }
+        if length < 0 {
+            return -1
+        }
+        n += length
+    }
+    return n + utf8.RuneLen(d.RecordSeparator)
+}
+
+// encodedFieldLen returns the number of bytes that a Writer with the settings
+// in d would write for field, or -1 if the Writer would reject it with
+// ErrNoEscape.
+func encodedFieldLen(field string, d Dialect) (n int) {
+    escape := d.escapeRune()
+    c, size := utf8.DecodeRuneInString(field)
+    if escape != 0 && d.Quote != 0 && size > 0 && c == d.Quote {
+        return d.escapeLen() + size + encodedFieldLen(field[size:], d)
+    }
+    quote := escape == 0 && d.Quote != 0 && (size > 0 && c == d.Quote || !utf8.ValidString(field))
+    for _, r := range field {
+        switch {
+            case r == escape && escape != 0, r == d.Separator, r == d.RecordSeparator:
+                if escape == 0 {
+                    if d.Quote == 0 {
+                        return -1
+                    }
+                    quote = true
+                }
+                n += d.escapeLen()
+        }
+        n += utf8.RuneLen(r)
+    }
+    if quote {
+        return quotedFieldLen(field, d.Quote)
+    }
+    return
+}
+
+// quotedFieldLen returns the number of bytes that a Writer would write for
+// field enclosed in quote characters.
+func quotedFieldLen(field string, quote rune) int {
+    n := 2 * utf8.RuneLen(quote)
+    for _, r := range field {
+        if r == quote {
+            n += utf8.RuneLen(quote)
+        }
+        n += utf8.RuneLen(r)
+    }
+    return n
+}
+
+// CSVQuoteField returns the decoded field s in a form that can be embedded in
+// CSV as a single field (RFC 4180): s is enclosed in double quotes, with each
+// double quote within it doubled, if it contains a comma, a double quote, a
+// carriage return, or a newline or if it begins or ends with a space or tab.
+// Otherwise s is returned unchanged.
+func CSVQuoteField(s string) string {
+    if s == "" {
+        return s
+    }
+    first, last := s[0], s[len(s) - 1]
+    if !strings.ContainsAny(s, ",\"\r\n") && first != ' ' && first != '\t' && last != ' ' && last != '\t' {
+        return s
+    }
+    return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// ShellQuoteField returns the decoded field s in a form that a POSIX shell
+// reads as a single word with the value s.  Fields consisting only of ASCII
+// letters, digits, and the characters "%+,-./:=@_" are returned unchanged;
+// others are enclosed in single quotes, within which each single quote is
+// written as '\''.  The empty field is returned as ''.
+func ShellQuoteField(s string) string {
+    if s != "" && strings.Trim(s, shellSafe) == "" {
+        return s
+    }
+    return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellSafe holds the characters that ShellQuoteField needn't quote.
+const shellSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789%+,-./:=@_"
+
+// Sniff guesses the dialect of sample, the beginning of some newline-separated
+// input, by choosing among colon-separated DSV, comma-separated CSV, and
+// tab-separated TSV.  A candidate separator's score is the fraction of the
+// sample's lines on which it occurs, unescaped, as often as on most lines, or
+// zero if it doesn't occur on most lines; separators escaped with a reverse
+// solidus ('\\') aren't counted.
+//
+// If a line has a field enclosed in double quotes ('"'), the sample is taken
+// to be quoted as CSV is: separators within quotes aren't counted, reverse
+// solidi are ordinary characters, and the returned Dialect has a Quote of '"'
+// and no escape character.  Otherwise its Escape is a reverse solidus.
+//
+// Sniff returns the highest-scoring separator (preferring the one occurring
+// more often per line in case of a tie) along with a confidence: its score
+// less the runner-up's.  If no candidate occurs, Sniff returns the default
+// dialect with zero confidence.  A final line without a newline is ignored
+// unless it is the only line, since it may have been truncated.
+func Sniff(sample []byte) (Dialect, float64) {
+    lines := strings.Split(string(sample), "\n")
+    if len(lines) > 1 {
+        lines = lines[:len(lines) - 1]
+    }
+    best := Dialect {Escape: '\\', Separator: ':', RecordSeparator: '\n'}
+    quoted := sniffQuoted(lines)
+    if quoted {
+        best.Escape, best.Quote = 0, '"'
+    }
+    var bestScore, secondScore float64
+    var bestCount int
+    for _, separator := range []rune {':', ',', '\t'} {
+        score, count := sniffSeparator(lines, separator, quoted)
+        if score > bestScore || (score == bestScore && score > 0 && count > bestCount) {
+            best.Separator, bestScore, secondScore, bestCount = separator, score, bestScore, count
+        } else if score > secondScore {
+            secondScore = score
+        }
+    }
+    return best, bestScore - secondScore
+}
+
+// sniffQuoted reports whether any of lines has a field enclosed in double
+// quotes: a '"' at the start of the line or after a candidate separator whose
+// closing '"' ends the line or precedes a candidate separator.
+func sniffQuoted(lines []string) bool {
+    for _, line := range lines {
+        for i := 0; i < len(line); i++ {
+            if line[i] != '"' || i > 0 && !strings.ContainsRune(":,\t", rune(line[i - 1])) {
+                continue
+            }
+            for j := i + 1; j < len(line); j++ {
+                if line[j] != '"' {
+                    continue
+                }
+                if j + 1 < len(line) && line[j + 1] == '"' {
+                    j++
+                    continue
+                }
+                if j + 1 == len(line) || strings.ContainsRune(":,\t", rune(line[j + 1])) {
+                    return true
+                }
+                break
+            }
+        }
+    }
+    return false
+}
+
+// sniffSeparator returns the most common number of unescaped occurrences of
+// separator on the non-empty lines and the fraction of those lines with that
+// many occurrences (zero if the most common number is zero).  If quoted is
+// set, occurrences within double quotes are ignored instead of escaped ones.
+func sniffSeparator(lines []string, separator rune, quoted bool) (score float64, count int) {
+    counts := make(map[int]int)
+    var total int
+    for _, line := range lines {
+        if line == "" {
+            continue
+        }
+        n := 0
+        for escaped, inQuotes, i := false, false, 0; i < len(line); i++ {
+            switch {
+                case quoted && line[i] == '"':
+                    inQuotes = !inQuotes
+                case inQuotes:
+                case escaped:
+                    escaped = false
+                case !quoted && line[i] == '\\':
+                    escaped = true
+                case rune(line[i]) == separator:
+                    n++
+            }
+        }
+        counts[n]++
+        total++
+    }
+    for n, lines := range counts {
+        if lines > counts[count] || (lines == counts[count] && n > count) {
+            count = n
+        }
+    }
+    if count == 0 {
+        return 0, 0
+    }
+    return float64(counts[count]) / float64(total), count
+}
+
+// runeReader returns r as an io.RuneReader, buffering it if necessary.
+func runeReader(r io.Reader) io.RuneReader {
+    if rr, ok := r.(io.RuneReader); ok {
+        return rr
+    }
+    return bufio.NewReader(r)
 }
@@ -12,10 +12,22 @@
 package dsv
 
 import (
+    "bufio"
     "bytes"
+    "compress/gzip"
+    "context"
+    "errors"
     "fmt"
+    "io"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "strconv"
     "strings"
     "testing"
+    "testing/iotest"
+    "time"
+    "unicode/utf8"
 )
 
 func TestDSV(t *testing.T) {
@@ -30,7 +42,7 @@ func TestDSV(t *testing.T) {
     if err != nil {
         t.Fatal("error while reading valid DSV string")
     }
-    t.Logf(fmt.Sprintf("%v", output))
+    t.Logf("%v", output)
     if len(output) != len(expectedOutput) {
         t.Fatal(fmt.Sprintf("output doesn't have the expected number of records: %v instead of %v",
             len(output), len(expectedOutput)))
@@ -58,3 +70,2918 @@ func TestDSV(t *testing.T) {
         t.Fatal("written DSV doesn't match original DSV string")
     }
 }
+
+func TestDialectRoundTrip(t *testing.T) {
+    reader := NewReader(strings.NewReader(""))
+    reader.Escape = '~'
+    reader.Separator = '|'
+    dialect := reader.Dialect()
+    if dialect.Escape != '~' || dialect.Separator != '|' {
+        t.Fatal(fmt.Sprintf("reader dialect doesn't match reader settings: %+v", dialect))
+    }
+
+    writer := NewWriter(&bytes.Buffer{})
+    writer.SetDialect(dialect)
+    if writer.Escape != reader.Escape || writer.Separator != reader.Separator {
+        t.Fatal("writer settings don't match dialect")
+    }
+    if writer.Dialect() != dialect {
+        t.Fatal(fmt.Sprintf("writer dialect %+v doesn't match reader dialect %+v",
+            writer.Dialect(), dialect))
+    }
+}
+
+func TestWriterAlwaysQuote(t *testing.T) {
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.Quote = '"'
+    writer.AlwaysQuote = true
+    err := writer.WriteAll([][]string {
+        {"a", "", "say \"hi\"", "b:c\\d"},
+        {""},
+    })
+    if err != nil {
+        t.Fatal("error while writing quoted DSV fields")
+    }
+    expected := "\"a\":\"\":\"say \"\"hi\"\"\":\"b:c\\d\"\n\"\"\n"
+    if buffer.String() != expected {
+        t.Fatal(fmt.Sprintf("quoted output %q doesn't match expected output %q",
+            buffer.String(), expected))
+    }
+}
+
+func TestRecordSeparator(t *testing.T) {
+    input := "a:b\\;c;;d"
+    expectedOutput := [][]string {
+        {"a", "b;c"},
+        {"d"},
+    }
+
+    reader := NewReader(strings.NewReader(input))
+    reader.RecordSeparator = ';'
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal("error while reading valid DSV string")
+    }
+    if fmt.Sprint(output) != fmt.Sprint(expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.RecordSeparator = ';'
+    if err = writer.WriteAll(output); err != nil {
+        t.Fatal("error while writing DSV fields")
+    }
+    if buffer.String() != "a:b\\;c;d;" {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV", buffer.String()))
+    }
+}
+
+func TestInvalidRecordSeparator(t *testing.T) {
+    tests := []struct {
+        recordSeparator rune
+        expectedError   error
+    } {
+        {0, ErrEmptyRecordSeparator},
+        {utf8.MaxRune + 1, ErrEmptyRecordSeparator},
+        {':', ErrRecordSeparatorConflict},
+        {'\\', ErrRecordSeparatorConflict},
+    }
+    for _, test := range tests {
+        reader := NewReader(strings.NewReader("a:b\n"))
+        reader.RecordSeparator = test.recordSeparator
+        if err := reader.Validate(); err != test.expectedError {
+            t.Fatal(fmt.Sprintf("Reader.Validate returned %v instead of %v for record separator %q",
+                err, test.expectedError, test.recordSeparator))
+        }
+        if record, err := reader.Read(); record != nil || err != test.expectedError {
+            t.Fatal(fmt.Sprintf("Read returned %q, %v instead of nil, %v for record separator %q",
+                record, err, test.expectedError, test.recordSeparator))
+        }
+
+        buffer := bytes.Buffer{}
+        writer := NewWriter(&buffer)
+        writer.RecordSeparator = test.recordSeparator
+        if err := writer.Validate(); err != test.expectedError {
+            t.Fatal(fmt.Sprintf("Writer.Validate returned %v instead of %v for record separator %q",
+                err, test.expectedError, test.recordSeparator))
+        }
+        if err := writer.WriteAll([][]string {{"a", "b"}}); err != test.expectedError {
+            t.Fatal(fmt.Sprintf("WriteAll returned %v instead of %v for record separator %q",
+                err, test.expectedError, test.recordSeparator))
+        }
+        if buffer.Len() != 0 {
+            t.Fatal("Writer with invalid record separator wrote data")
+        }
+    }
+}
+
+func TestTranscode(t *testing.T) {
+    input := "a\\:b:c\\\\d:e|f:g~h\nmulti\\\nline:x|~:\n"
+    expectedOutput := "a:b|c\\d|e~|f|g~~h\nmulti~\nline|x~|~~|\n"
+    from := Dialect {Escape: '\\', Separator: ':', RecordSeparator: '\n'}
+    to := Dialect {Escape: '~', Separator: '|', RecordSeparator: '\n'}
+
+    buffer := bytes.Buffer{}
+    records, err := Transcode(&buffer, strings.NewReader(input), from, to)
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while transcoding valid DSV string: %v", err))
+    }
+    if records != 2 {
+        t.Fatal(fmt.Sprintf("transcoded %v records instead of 2", records))
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("transcoded DSV %q doesn't match expected DSV %q",
+            buffer.String(), expectedOutput))
+    }
+
+    original := bytes.Buffer{}
+    if _, err = Transcode(&original, &buffer, to, from); err != nil {
+        t.Fatal(fmt.Sprintf("error while transcoding DSV back: %v", err))
+    }
+    if original.String() != input {
+        t.Fatal(fmt.Sprintf("DSV transcoded back %q doesn't match original DSV %q",
+            original.String(), input))
+    }
+}
+
+func TestTranscodeEscapeChange(t *testing.T) {
+    input := "a^:b\\c:^^d\\^^:e^\nf\n"
+    expectedOutput := "a\\:b\\\\c:^d\\\\^:e\\\nf\n"
+    expectedRecords := [][]string {
+        {"a:b\\c", "^d\\^", "e\nf"},
+    }
+    from := Dialect {Escape: '^', Separator: ':', RecordSeparator: '\n'}
+    to := Dialect {Escape: '\\', Separator: ':', RecordSeparator: '\n'}
+
+    buffer := bytes.Buffer{}
+    if _, err := Transcode(&buffer, strings.NewReader(input), from, to); err != nil {
+        t.Fatal(fmt.Sprintf("error while transcoding valid DSV string: %v", err))
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("transcoded DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+    reader := NewReader(strings.NewReader(buffer.String()))
+    reader.SetDialect(to)
+    records, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading transcoded DSV: %v", err))
+    }
+    if fmt.Sprintf("%q", records) != fmt.Sprintf("%q", expectedRecords) {
+        t.Fatal(fmt.Sprintf("transcoded records %q don't match original records %q", records, expectedRecords))
+    }
+
+    original := bytes.Buffer{}
+    if _, err = Transcode(&original, &buffer, to, from); err != nil {
+        t.Fatal(fmt.Sprintf("error while transcoding DSV back: %v", err))
+    }
+    if original.String() != input {
+        t.Fatal(fmt.Sprintf("DSV transcoded back %q doesn't match original DSV %q", original.String(), input))
+    }
+}
+
+func TestCanonicalize(t *testing.T) {
+    d := Dialect {Escape: '\\', Separator: ':', RecordSeparator: '\n'}
+    input := "\\a\\b:c\\:d\\\\:\\e\\\n\\f\n\n\\g"
+    expectedOutput := "ab:c\\:d\\\\:e\\\nf\ng\n"
+
+    buffer := bytes.Buffer{}
+    if err := Canonicalize(&buffer, strings.NewReader(input), d); err != nil {
+        t.Fatal(fmt.Sprintf("error while canonicalizing valid DSV string: %v", err))
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("canonical DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+
+    expectedRecords, err := NewReader(strings.NewReader(input)).ReadAll()
+    if err != nil {
+        t.Fatal("error while reading over-escaped DSV")
+    }
+    records, err := NewReader(&buffer).ReadAll()
+    if err != nil {
+        t.Fatal("error while reading canonical DSV")
+    }
+    if fmt.Sprintf("%q", records) != fmt.Sprintf("%q", expectedRecords) {
+        t.Fatal(fmt.Sprintf("canonical records %q don't match original records %q", records, expectedRecords))
+    }
+}
+
+func TestParseRecord(t *testing.T) {
+    dialect := Dialect {Escape: '~', Separator: '|', RecordSeparator: '\n'}
+    fields, err := ParseRecord("a|b~|c|~~\n", dialect)
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while parsing a single record: %v", err))
+    }
+    if fmt.Sprint(fields) != fmt.Sprint([]string {"a", "b|c", "~"}) || len(fields) != 3 {
+        t.Fatal(fmt.Sprintf("parsed fields %q don't match expected fields", fields))
+    }
+
+    fields, err = ParseRecord("a|b\n\nc|d\n", dialect)
+    if err != ErrMultipleRecords {
+        t.Fatal(fmt.Sprintf("ParseRecord returned %v instead of ErrMultipleRecords for two records", err))
+    }
+    if fields != nil {
+        t.Fatal(fmt.Sprintf("ParseRecord returned fields %q along with an error", fields))
+    }
+}
+
+func TestEncodeRecord(t *testing.T) {
+    dialect := Dialect {Escape: '\\', Separator: ':', RecordSeparator: '\n'}
+    innermost := []string {"x:y", "z\\"}
+    inner, err := EncodeRecord(innermost, dialect)
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while encoding a record: %v", err))
+    }
+    if inner != "x\\:y:z\\\\" {
+        t.Fatal(fmt.Sprintf("encoded record %q doesn't match the expected encoding", inner))
+    }
+    outer, err := EncodeRecord([]string {"id", inner, "end\n"}, dialect)
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while encoding a nested record: %v", err))
+    }
+
+    fields, err := ParseRecord(outer, dialect)
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while parsing a nested record: %v", err))
+    }
+    if len(fields) != 3 || fields[1] != inner || fields[2] != "end\n" {
+        t.Fatal(fmt.Sprintf("parsed fields %q don't match the encoded fields", fields))
+    }
+    if fields, err = ParseRecord(fields[1], dialect); err != nil {
+        t.Fatal(fmt.Sprintf("error while parsing an inner record: %v", err))
+    }
+    if fmt.Sprintf("%q", fields) != fmt.Sprintf("%q", innermost) {
+        t.Fatal(fmt.Sprintf("inner fields %q don't match the encoded fields %q", fields, innermost))
+    }
+}
+
+func TestReaderGzip(t *testing.T) {
+    input := "a:b\\:c\n\nd:e\\\nf\n"
+    compressed := bytes.Buffer{}
+    gz := gzip.NewWriter(&compressed)
+    if _, err := gz.Write([]byte(input)); err != nil {
+        t.Fatal("error while compressing DSV string")
+    }
+    if err := gz.Close(); err != nil {
+        t.Fatal("error while compressing DSV string")
+    }
+
+    expectedOutput, err := NewReader(strings.NewReader(input)).ReadAll()
+    if err != nil {
+        t.Fatal("error while reading valid DSV string")
+    }
+    reader, err := NewReaderGzip(&compressed)
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while creating gzip Reader: %v", err))
+    }
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal("error while reading valid compressed DSV string")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("compressed output %q doesn't match uncompressed output %q",
+            output, expectedOutput))
+    }
+
+    if _, err = NewReaderGzip(strings.NewReader(input)); err == nil {
+        t.Fatal("NewReaderGzip didn't report an error for uncompressed input")
+    }
+}
+
+func TestWriterGzip(t *testing.T) {
+    records := [][]string {
+        {"a", "b:c"},
+        {"d", "e\nf", "\\"},
+    }
+    compressed := bytes.Buffer{}
+    writer, closeWriter := NewWriterGzip(&compressed)
+    for _, record := range records {
+        if err := writer.Write(record); err != nil {
+            t.Fatal("error while writing DSV fields")
+        }
+    }
+    if err := closeWriter(); err != nil {
+        t.Fatal(fmt.Sprintf("error while closing gzip Writer: %v", err))
+    }
+
+    gz, err := gzip.NewReader(&compressed)
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading gzip header: %v", err))
+    }
+    output, err := NewReader(bufio.NewReader(gz)).ReadAll()
+    if err != nil {
+        t.Fatal("error while reading compressed DSV")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("decompressed records %q don't match written records %q",
+            output, records))
+    }
+}
+
+func TestWriteHeaderOnce(t *testing.T) {
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    header := []string {"name", "value"}
+    if err := writer.WriteHeader(header); err != nil {
+        t.Fatal("error while writing header")
+    }
+    if err := writer.Write([]string {"a", "1"}); err != nil {
+        t.Fatal("error while writing DSV fields")
+    }
+    if err := writer.WriteHeader(header); err != nil {
+        t.Fatal("error while writing header a second time")
+    }
+    writer.Flush()
+    if buffer.String() != "name:value\na:1\n" {
+        t.Fatal(fmt.Sprintf("written DSV %q contains more than one header", buffer.String()))
+    }
+
+    buffer.Reset()
+    writer = NewWriter(&buffer)
+    writer.SetHeaderWritten(true)
+    if err := writer.WriteHeader(header); err != nil {
+        t.Fatal("error while writing header")
+    }
+    writer.Flush()
+    if buffer.Len() != 0 {
+        t.Fatal(fmt.Sprintf("header %q written after SetHeaderWritten(true)", buffer.String()))
+    }
+}
+
+func TestRecordOffset(t *testing.T) {
+    input := "\n\na:b\\\nc\n\n\nd\\:e:f\nlast"
+    reader := NewReader(strings.NewReader(input))
+    var records [][]string
+    var offsets []int64
+    for {
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal("error while reading valid DSV string")
+        }
+        if record == nil {
+            break
+        }
+        records = append(records, record)
+        offsets = append(offsets, reader.RecordOffset())
+    }
+    if fmt.Sprint(offsets) != "[2 11 18]" {
+        t.Fatal(fmt.Sprintf("record offsets %v aren't the expected offsets", offsets))
+    }
+
+    source := bytes.NewReader([]byte(input))
+    for n := len(offsets) - 1; n >= 0; n-- {
+        if _, err := source.Seek(offsets[n], io.SeekStart); err != nil {
+            t.Fatal("error while seeking DSV source")
+        }
+        record, err := NewReader(source).Read()
+        if err != nil {
+            t.Fatal("error while reading DSV at record offset")
+        }
+        if fmt.Sprintf("%q", record) != fmt.Sprintf("%q", records[n]) {
+            t.Fatal(fmt.Sprintf("record %q at offset %v doesn't match record %q",
+                record, offsets[n], records[n]))
+        }
+    }
+}
+
+func TestReaderStats(t *testing.T) {
+    input := "a:\u00e9\n\nbc\\\nd\n"
+    expectedStats := []ReaderStats {
+        {Records: 1, Runes: 4, Bytes: 5, RecordRunes: 4, RecordBytes: 5},
+        {Records: 2, Runes: 11, Bytes: 12, RecordRunes: 7, RecordBytes: 7},
+    }
+    reader := NewReader(strings.NewReader(input))
+    reader.CollectStats = true
+    for _, expected := range expectedStats {
+        if _, err := reader.Read(); err != nil {
+            t.Fatal("error while reading valid DSV string")
+        }
+        if reader.Stats() != expected {
+            t.Fatal(fmt.Sprintf("stats %+v don't match expected stats %+v", reader.Stats(), expected))
+        }
+    }
+
+    reader = NewReader(strings.NewReader(input))
+    reader.CollectStats = true
+    if _, err := reader.Read(); err != nil {
+        t.Fatal("error while reading valid DSV string")
+    }
+    reader.ResetStats()
+    if reader.Stats() != (ReaderStats {}) {
+        t.Fatal(fmt.Sprintf("ResetStats left stats %+v", reader.Stats()))
+    }
+    record, err := reader.Read()
+    if err != nil || fmt.Sprintf("%q", record) != fmt.Sprintf("%q", []string {"bc\nd"}) {
+        t.Fatal(fmt.Sprintf("Read returned %q and %v after ResetStats", record, err))
+    }
+    expected := ReaderStats {Records: 1, Runes: 7, Bytes: 7, RecordRunes: 7, RecordBytes: 7}
+    if reader.Stats() != expected {
+        t.Fatal(fmt.Sprintf("stats %+v after ResetStats don't match expected stats %+v", reader.Stats(), expected))
+    }
+
+    reader = NewReader(strings.NewReader(input))
+    if _, err := reader.ReadAll(); err != nil {
+        t.Fatal("error while reading valid DSV string")
+    }
+    if reader.Stats() != (ReaderStats {}) {
+        t.Fatal(fmt.Sprintf("Reader collected stats %+v without CollectStats", reader.Stats()))
+    }
+}
+
+func TestCopyWithoutFinalRecordSeparator(t *testing.T) {
+    buffer := bytes.Buffer{}
+    records, err := Copy(NewWriter(&buffer), NewReader(strings.NewReader("a:b\nc")))
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while copying valid DSV string: %v", err))
+    }
+    if records != 2 || buffer.String() != "a:b\nc\n" {
+        t.Fatal(fmt.Sprintf("copied %v records %q instead of 2 records", records, buffer.String()))
+    }
+}
+
+func TestSeekRecord(t *testing.T) {
+    input := "a:b\nc\\:d:e\n\nf:g\n"
+    reader := NewReader(bytes.NewReader([]byte(input)))
+    var offsets []int64
+    for {
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal("error while reading valid DSV string")
+        }
+        if record == nil {
+            break
+        }
+        offsets = append(offsets, reader.RecordOffset())
+    }
+
+    if err := reader.SeekRecord(offsets[1]); err != nil {
+        t.Fatal(fmt.Sprintf("error while seeking to record offset: %v", err))
+    }
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal("error while reading DSV after seeking")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", [][]string {{"c:d", "e"}, {"f", "g"}}) {
+        t.Fatal(fmt.Sprintf("records %q read after seeking aren't the expected records", output))
+    }
+    if reader.RecordOffset() != offsets[2] {
+        t.Fatal(fmt.Sprintf("record offset %v after seeking isn't %v", reader.RecordOffset(), offsets[2]))
+    }
+
+    if err = NewReader(bufio.NewReader(strings.NewReader(input))).SeekRecord(0); err != ErrNotSeekable {
+        t.Fatal(fmt.Sprintf("SeekRecord returned %v instead of ErrNotSeekable", err))
+    }
+}
+
+func TestSkipBOM(t *testing.T) {
+    input := "\uFEFFa:b\n\uFEFFc\n"
+    output, err := NewReader(strings.NewReader(input)).ReadAll()
+    if err != nil {
+        t.Fatal("error while reading valid DSV string")
+    }
+    expectedOutput := [][]string {{"a", "b"}, {"\uFEFFc"}}
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+
+    reader := NewReader(strings.NewReader(input))
+    reader.SkipBOM = false
+    record, err := reader.Read()
+    if err != nil {
+        t.Fatal("error while reading valid DSV string")
+    }
+    if record[0] != "\uFEFFa" {
+        t.Fatal(fmt.Sprintf("first field %q doesn't start with a byte order mark", record[0]))
+    }
+}
+
+func TestMultilineFieldRoundTrip(t *testing.T) {
+    random := rand.New(rand.NewSource(1))
+    runes := []rune("ab:\\ é€\t")
+    for n := 0; n < 500; n++ {
+        var field []rune
+        for length := random.Intn(40); len(field) < length; {
+            if random.Intn(4) == 0 {
+                field = append(field, '\n')
+            } else {
+                field = append(field, runes[random.Intn(len(runes))])
+            }
+        }
+        record := []string {"note", string(field), "end"}
+
+        buffer := bytes.Buffer{}
+        if err := NewWriter(&buffer).WriteAll([][]string {record, record}); err != nil {
+            t.Fatal("error while writing DSV fields")
+        }
+
+        // Vary where the source's reads end by reading the encoded record
+        // through a small buffer one byte at a time.
+        source := bufio.NewReaderSize(iotest.OneByteReader(bytes.NewReader(buffer.Bytes())), 16 + n % 16)
+        output, err := NewReader(source).ReadAll()
+        if err != nil {
+            t.Fatal("error while reading DSV with multiline fields")
+        }
+        if len(output) != 2 {
+            t.Fatal(fmt.Sprintf("read %v records instead of 2 from %q", len(output), buffer.String()))
+        }
+        for _, result := range output {
+            if fmt.Sprintf("%q", result) != fmt.Sprintf("%q", record) {
+                t.Fatal(fmt.Sprintf("decoded record %q doesn't match original record %q", result, record))
+            }
+        }
+    }
+}
+
+func TestRawRecord(t *testing.T) {
+    input := "\na\\:b:c\\\\\\\nd\n\ne::\\f\nlast\\:"
+    reader := NewReader(strings.NewReader(input))
+    reader.KeepRaw = true
+    for _, expected := range []string {"a\\:b:c\\\\\\\nd", "e::\\f", "last\\:"} {
+        record, err := reader.Read()
+        if err != nil || record == nil {
+            t.Fatal("error while reading valid DSV string")
+        }
+        offset := reader.RecordOffset()
+        raw := string(reader.RawRecord())
+        if raw != expected || raw != input[offset:offset + int64(len(raw))] {
+            t.Fatal(fmt.Sprintf("raw record %q doesn't match original input %q", raw, expected))
+        }
+    }
+
+    reader = NewReader(strings.NewReader(input))
+    if _, err := reader.Read(); err != nil {
+        t.Fatal("error while reading valid DSV string")
+    }
+    if reader.RawRecord() != nil {
+        t.Fatal("RawRecord returned raw bytes without KeepRaw")
+    }
+}
+
+func TestTrimTrailingEmptyFields(t *testing.T) {
+    input := "a:b:\nc::\n:\n"
+    tests := []struct {
+        trim, trimAll   bool
+        expectedOutput  string
+    } {
+        {false, false, `[["a" "b" ""] ["c" "" ""] ["" ""]]`},
+        {true, false, `[["a" "b"] ["c" ""] [""]]`},
+        {false, true, `[["a" "b"] ["c"] [""]]`},
+    }
+    for _, test := range tests {
+        reader := NewReader(strings.NewReader(input))
+        reader.TrimTrailingEmptyFields = test.trim
+        reader.TrimAllTrailingEmptyFields = test.trimAll
+        output, err := reader.ReadAll()
+        if err != nil {
+            t.Fatal("error while reading valid DSV string")
+        }
+        if fmt.Sprintf("%q", output) != test.expectedOutput {
+            t.Fatal(fmt.Sprintf("output %q doesn't match expected output %v", output, test.expectedOutput))
+        }
+    }
+}
+
+func TestSplitLimit(t *testing.T) {
+    input := "k:v1:v2\nkey:a\\:b:c\nsingle\nempty:\n"
+    expectedOutput := [][]string {
+        {"k", "v1:v2"},
+        {"key", "a:b:c"},
+        {"single"},
+        {"empty", ""},
+    }
+    reader := NewReader(strings.NewReader(input))
+    reader.SplitLimit = 2
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal("error while reading DSV with a split limit")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+}
+
+func TestWriterSplitLimit(t *testing.T) {
+    records := [][]string {
+        {"k", "v1:v2"},
+        {"key\\:x", "a\\b\n:c"},
+        {"single"},
+    }
+    expectedOutput := "k:v1:v2\nkey\\\\\\:x:a\\\\b\\\n:c\nsingle\n"
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.SplitLimit = 2
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal("error while writing DSV with a split limit")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+
+    reader := NewReader(&buffer)
+    reader.SplitLimit = 2
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal("error while reading DSV with a split limit")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("records %q read back don't match written records %q", output, records))
+    }
+
+    err = writer.Write([]string {"a", "b", "c"})
+    if writeErr, ok := err.(*WriteError); !ok || writeErr.Err != ErrTooManyFields || writeErr.Field != 3 {
+        t.Fatal(fmt.Sprintf("Write returned %v instead of ErrTooManyFields in field 3", err))
+    }
+}
+
+func TestVerbatimField(t *testing.T) {
+    records := [][]string {
+        {"note", "free text: with \\ and : inside"},
+        {"a:b", "c\\"},
+        {"short"},
+    }
+    expectedOutput := "note:free text: with \\ and : inside\na\\:b:c\\\nshort\n"
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.VerbatimField(1)
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal("error while writing DSV with a verbatim field")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+    err := writer.Write([]string {"a", "b\nc"})
+    if writeErr, ok := err.(*WriteError); !ok || writeErr.Err != ErrVerbatimField || writeErr.Record != 4 || writeErr.Field != 2 {
+        t.Fatal(fmt.Sprintf("Write returned %v instead of ErrVerbatimField in record 4 field 2", err))
+    }
+
+    for _, split := range []bool {false, true} {
+        reader := NewReader(strings.NewReader(expectedOutput))
+        reader.SplitLimit = 2
+        reader.VerbatimLastField = true
+        if split {
+            reader.Split = bufio.ScanLines
+        }
+        output, err := reader.ReadAll()
+        if err != nil {
+            t.Fatal("error while reading DSV with a verbatim field")
+        }
+        if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+            t.Fatal(fmt.Sprintf("records %q read back don't match written records %q", output, records))
+        }
+    }
+}
+
+func TestWindowsLineEndings(t *testing.T) {
+    records := [][]string {
+        {"a", "b\r\nc"},
+        {"d\re"},
+        {"f"},
+    }
+    expectedOutput := "a:b\\\r\\\nc\r\nd\\\re\r\nf"
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.WindowsLineEndings = true
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal("error while writing DSV with Windows line endings")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+}
+
+func TestWriteFlush(t *testing.T) {
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    if err := writer.WriteFlush([]string {"hello", "world"}); err != nil {
+        t.Fatal("error while writing DSV fields")
+    }
+    if buffer.String() != "hello:world\n" {
+        t.Fatal(fmt.Sprintf("underlying writer received %q instead of the flushed record", buffer.String()))
+    }
+    if err := writer.WriteFlush([]string {"again"}); err != nil {
+        t.Fatal("error while writing DSV fields")
+    }
+    if buffer.String() != "hello:world\nagain\n" {
+        t.Fatal(fmt.Sprintf("underlying writer received %q instead of both flushed records", buffer.String()))
+    }
+}
+
+func TestLeadingBlankLines(t *testing.T) {
+    input := "\n\na\nb\n\nc\n\n\n\nd\n\n"
+    reader := NewReader(strings.NewReader(input))
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    var counts []int
+    for {
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal("error while reading valid DSV string")
+        }
+        counts = append(counts, reader.LeadingBlankLines())
+        buffer.WriteString(strings.Repeat("\n", reader.LeadingBlankLines()))
+        if record == nil {
+            break
+        }
+        writer.WriteFlush(record)
+    }
+    if fmt.Sprint(counts) != "[2 0 1 3 1]" {
+        t.Fatal(fmt.Sprintf("blank line counts %v aren't the expected counts", counts))
+    }
+    if buffer.String() != input {
+        t.Fatal(fmt.Sprintf("rewritten DSV %q doesn't preserve the blank lines of %q", buffer.String(), input))
+    }
+}
+
+func TestKeepLeadingBlanks(t *testing.T) {
+    input := "\n\na:b\n\nc\n"
+    tests := []struct {
+        keep            bool
+        expectedOutput  [][]string
+    } {
+        {false, [][]string {{"a", "b"}, {"c"}}},
+        {true, [][]string {{""}, {""}, {"a", "b"}, {"c"}}},
+    }
+    for _, test := range tests {
+        reader := NewReader(strings.NewReader(input))
+        reader.KeepLeadingBlanks = test.keep
+        output, err := reader.ReadAll()
+        if err != nil {
+            t.Fatal("error while reading DSV with leading blank lines")
+        }
+        if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", test.expectedOutput) {
+            t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q with KeepLeadingBlanks %v",
+                output, test.expectedOutput, test.keep))
+        }
+    }
+}
+
+func TestPhysicalLines(t *testing.T) {
+    input := "one:line\nthree\\\nphysical\\\nlines\n\nquoted:\"a\nb\"\n"
+    reader := NewReader(strings.NewReader(input))
+    reader.Quote = '"'
+    var lines []int
+    for {
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal("error while reading DSV with continuation lines")
+        }
+        if record == nil {
+            break
+        }
+        lines = append(lines, reader.PhysicalLines())
+    }
+    if fmt.Sprint(lines) != "[1 3 2]" {
+        t.Fatal(fmt.Sprintf("records spanned %v physical lines instead of [1 3 2]", lines))
+    }
+}
+
+func TestOnBlankLines(t *testing.T) {
+    input := "\na\n\n\nb\nc\n\n"
+    var events []string
+    reader := NewReader(strings.NewReader(input))
+    reader.OnBlankLines = func(count int) {
+        events = append(events, fmt.Sprint(count))
+    }
+    for {
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal("error while reading DSV with blank lines")
+        }
+        if record == nil {
+            break
+        }
+        events = append(events, record[0])
+    }
+    if strings.Join(events, " ") != "1 a 2 b c 1" {
+        t.Fatal(fmt.Sprintf("blank line counts and records %v don't match the input", events))
+    }
+}
+
+func TestReaderChannel(t *testing.T) {
+    input := "a:b\nc\\:d\n\ne\n"
+    records, errs := NewReader(strings.NewReader(input)).Channel(context.Background())
+    var output [][]string
+    for record := range records {
+        output = append(output, record)
+    }
+    if err := <-errs; err != nil {
+        t.Fatal(fmt.Sprintf("error while reading records from channel: %v", err))
+    }
+    if fmt.Sprintf("%q", output) != `[["a" "b"] ["c:d"] ["e"]]` {
+        t.Fatal(fmt.Sprintf("records %q from channel don't match input records", output))
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    records, errs = NewReader(strings.NewReader(input)).Channel(ctx)
+    if record := <-records; fmt.Sprintf("%q", record) != `["a" "b"]` {
+        t.Fatal(fmt.Sprintf("first record %q from channel isn't the first input record", record))
+    }
+    cancel()
+    for range records {
+    }
+    if err := <-errs; err != context.Canceled {
+        t.Fatal(fmt.Sprintf("error channel returned %v instead of context.Canceled", err))
+    }
+}
+
+func TestWriterChannel(t *testing.T) {
+    input := [][]string {{"a", "b:c"}, {"d\ne"}, {"f", ""}}
+    records := make(chan []string)
+    go func() {
+        for _, record := range input {
+            records <- record
+        }
+        close(records)
+    }()
+    buffer := bytes.Buffer{}
+    if err := NewWriter(&buffer).WriteChannel(context.Background(), records); err != nil {
+        t.Fatal(fmt.Sprintf("error while writing records from channel: %v", err))
+    }
+    output, err := NewReader(&buffer).ReadAll()
+    if err != nil {
+        t.Fatal("error while reading DSV written from channel")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", input) {
+        t.Fatal(fmt.Sprintf("records %q read back don't match records %q sent on channel", output, input))
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err = NewWriter(&buffer).WriteChannel(ctx, make(chan []string)); err != context.Canceled {
+        t.Fatal(fmt.Sprintf("WriteChannel returned %v instead of context.Canceled", err))
+    }
+}
+
+func TestProtectRune(t *testing.T) {
+    input := [][]string {{"a|b|c", "plain"}, {"|", "x:y|z"}}
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.ProtectRune('|')
+    if err := writer.WriteAll(input); err != nil {
+        t.Fatal("error while writing DSV fields")
+    }
+    if buffer.String() != "a\\|b\\|c:plain\n\\|:x\\:y\\|z\n" {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't escape protected runes", buffer.String()))
+    }
+    output, err := NewReader(&buffer).ReadAll()
+    if err != nil {
+        t.Fatal("error while reading DSV with protected runes")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", input) {
+        t.Fatal(fmt.Sprintf("records %q read back don't match written records %q", output, input))
+    }
+}
+
+func TestReadUntil(t *testing.T) {
+    input := "a:b\nc\nEND\nd:e\nEND\nf\n"
+    isEnd := func(record []string) bool {
+        return len(record) == 1 && record[0] == "END"
+    }
+    expectedSections := [][][]string {
+        {{"a", "b"}, {"c"}},
+        {{"d", "e"}},
+        {{"f"}},
+        nil,
+    }
+    reader := NewReader(strings.NewReader(input))
+    for _, expectedSection := range expectedSections {
+        section, err := reader.ReadUntil(isEnd)
+        if err != nil {
+            t.Fatal("error while reading a DSV section")
+        }
+        if fmt.Sprintf("%q", section) != fmt.Sprintf("%q", expectedSection) {
+            t.Fatal(fmt.Sprintf("section %q doesn't match expected section %q", section, expectedSection))
+        }
+    }
+}
+
+func TestMaxRecords(t *testing.T) {
+    reader := NewReader(strings.NewReader("a\nb\nc\nd\n"))
+    reader.MaxRecords = 2
+    output, err := reader.ReadAll()
+    if err != ErrTooManyRecords {
+        t.Fatal(fmt.Sprintf("ReadAll returned %v instead of ErrTooManyRecords", err))
+    }
+    if fmt.Sprintf("%q", output) != `[["a"] ["b"]]` {
+        t.Fatal(fmt.Sprintf("ReadAll returned records %q instead of the first two records", output))
+    }
+
+    reader = NewReader(strings.NewReader("a\nb\n"))
+    reader.MaxRecords = 2
+    if output, err = reader.ReadAll(); err != nil || len(output) != 2 {
+        t.Fatal(fmt.Sprintf("ReadAll returned %q, %v for input within the record limit", output, err))
+    }
+}
+
+type closeRecorder struct {
+    *strings.Reader
+    closed  bool
+}
+
+func (c *closeRecorder) Close() error {
+    c.closed = true
+    return nil
+}
+
+func TestReaderClose(t *testing.T) {
+    source := &closeRecorder {Reader: strings.NewReader("a:b\n")}
+    reader := NewReader(source)
+    if _, err := reader.ReadAll(); err != nil {
+        t.Fatal("error while reading valid DSV string")
+    }
+    if err := reader.Close(); err != nil {
+        t.Fatal(fmt.Sprintf("error while closing Reader: %v", err))
+    }
+    if !source.closed {
+        t.Fatal("Close didn't close the Reader's source")
+    }
+
+    if err := NewReader(strings.NewReader("a:b\n")).Close(); err != nil {
+        t.Fatal(fmt.Sprintf("Close returned %v for a source that isn't an io.Closer", err))
+    }
+}
+
+func TestMultiReader(t *testing.T) {
+    first := &closeRecorder {Reader: strings.NewReader("a:b\nc:long fi")}
+    second := &closeRecorder {Reader: strings.NewReader("eld\\")}
+    third := strings.NewReader(":\xc3")
+    fourth := &closeRecorder {Reader: strings.NewReader("\xa9\nd\n")}
+    expectedOutput := [][]string {
+        {"a", "b"},
+        {"c", "long field:\u00e9"},
+        {"d"},
+    }
+    reader := NewMultiReader(first, second, third, fourth)
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal("error while reading DSV from multiple sources")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+    if err = reader.Close(); err != nil {
+        t.Fatal(fmt.Sprintf("error while closing Reader: %v", err))
+    }
+    if !first.closed || !second.closed || !fourth.closed {
+        t.Fatal("Close didn't close every source")
+    }
+}
+
+func TestLengthPrefixed(t *testing.T) {
+    records := [][]string {
+        {"abc", "defg"},
+        {"\x00\xff:\n\\", "", "\u00e9\xc3"},
+        {"12:34\n"},
+    }
+    expectedOutput := "3:abc4:defg\n5:\x00\xff:\n\\0:3:\u00e9\xc3\n6:12:34\n\n"
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.LengthPrefixed = true
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal("error while writing length-prefixed DSV")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+
+    reader := NewReader(&buffer)
+    reader.LengthPrefixed = true
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading length-prefixed DSV: %v", err))
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("records %q read back don't match written records %q", output, records))
+    }
+
+    for _, input := range []string {"3:abc:x\n", "3:ab", "12"} {
+        reader = NewReader(strings.NewReader(input))
+        reader.LengthPrefixed = true
+        if _, err = reader.Read(); err == nil {
+            t.Fatal(fmt.Sprintf("Read didn't return an error for malformed length-prefixed DSV %q", input))
+        }
+        if _, ok := err.(*ParseError); !ok {
+            t.Fatal(fmt.Sprintf("Read returned %v instead of a ParseError for %q", err, input))
+        }
+    }
+}
+
+func TestSplit(t *testing.T) {
+    input := "a:bcdee:f\\:g\n:\nijknext:"
+    expectedOutput := [][]string {
+        {"a", "bcde"},
+        {"e", "f:g"},
+        {"\n", "\nijk"},
+        {"next", ""},
+    }
+    fixedLength := func(data []byte, atEOF bool) (int, []byte, error) {
+        if len(data) >= 6 {
+            return 6, data[:6], nil
+        }
+        if atEOF && len(data) > 0 {
+            return len(data), data, nil
+        }
+        return 0, nil, nil
+    }
+    reader := NewReader(strings.NewReader(input))
+    reader.Split = fixedLength
+    var output [][]string
+    var offsets []int64
+    for {
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal(fmt.Sprintf("error while reading DSV with a custom splitter: %v", err))
+        }
+        if record == nil {
+            break
+        }
+        output = append(output, record)
+        offsets = append(offsets, reader.RecordOffset())
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+    if fmt.Sprint(offsets) != "[0 6 12 18]" {
+        t.Fatal(fmt.Sprintf("record offsets %v aren't the expected offsets", offsets))
+    }
+}
+
+type eventRecorder struct {
+    events      []string
+    closeErr    error
+}
+
+func (e *eventRecorder) Write(p []byte) (int, error) {
+    e.events = append(e.events, "write " + string(p))
+    return len(p), nil
+}
+
+func (e *eventRecorder) Close() error {
+    e.events = append(e.events, "close")
+    return e.closeErr
+}
+
+func TestWriterClose(t *testing.T) {
+    sink := &eventRecorder {}
+    writer := NewWriter(sink)
+    if err := writer.Write([]string {"a", "b"}); err != nil {
+        t.Fatal("error while writing DSV fields")
+    }
+    if err := writer.Close(); err != nil {
+        t.Fatal(fmt.Sprintf("error while closing Writer: %v", err))
+    }
+    if fmt.Sprintf("%q", sink.events) != `["write a:b\n" "close"]` {
+        t.Fatal(fmt.Sprintf("sink events %q aren't a flush followed by a close", sink.events))
+    }
+
+    sink = &eventRecorder {closeErr: io.ErrClosedPipe}
+    if err := NewWriter(sink).Close(); err != io.ErrClosedPipe {
+        t.Fatal(fmt.Sprintf("Close returned %v instead of the sink's Close error", err))
+    }
+}
+
+func TestEncodedLen(t *testing.T) {
+    dialects := []Dialect {
+        {Escape: '\\', Separator: ':', RecordSeparator: '\n'},
+        {Escape: '€', Separator: '|', RecordSeparator: '¶'},
+        {Escape: '\\', Separator: ':', RecordSeparator: '\n', Quote: '"', Comment: '#'},
+        {Separator: ',', RecordSeparator: '\n', Quote: '"', Comment: '#'},
+        {EscapeString: "\\\\", Separator: ',', RecordSeparator: '\n', Quote: '\'', Comment: '#'},
+    }
+    records := [][]string {
+        {"plain"},
+        {"", ""},
+        {"a:b", "c\\d", "e\nf", "\\\\::\n\n"},
+        {"€|¶", "|||", "¶€", "é \xff"},
+        {"\"a\"b\"", "\"", "a,\"b\"", "'c'", "x\x00"},
+        {"#a", "#b", "#\"c", "#"},
+    }
+    for _, dialect := range dialects {
+        for _, record := range records {
+            buffer := bytes.Buffer{}
+            writer := NewWriter(&buffer)
+            writer.SetDialect(dialect)
+            if err := writer.WriteFlush(record); err != nil {
+                t.Fatal("error while writing DSV fields")
+            }
+            if n := EncodedLen(record, dialect); n != buffer.Len() {
+                t.Fatal(fmt.Sprintf("EncodedLen returned %v for record %q but Write wrote %v bytes",
+                    n, record, buffer.Len()))
+            }
+        }
+    }
+    dialect := Dialect {Separator: ',', RecordSeparator: '\n'}
+    if n := EncodedLen([]string {"a", "b"}, dialect); n != 4 {
+        t.Fatal(fmt.Sprintf("EncodedLen returned %v instead of 4 without escapes or quotes", n))
+    }
+    if n := EncodedLen([]string {"a,", "b"}, dialect); n != -1 {
+        t.Fatal(fmt.Sprintf("EncodedLen returned %v for a record that needs escaping without escapes or quotes", n))
+    }
+}
+
+func TestRawFieldsRoundTrip(t *testing.T) {
+    records := [][][]byte {
+        {{0xff, 0xfe, ':', 0x00}, {}, {'\\', 0xc3, '\n', 0xe2, 0x82}},
+        {[]byte("é€"), {0x80, 0x80, 0xc0, 0xaf}},
+    }
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    for _, record := range records {
+        if err := writer.WriteRaw(record); err != nil {
+            t.Fatal("error while writing raw DSV fields")
+        }
+    }
+    writer.Flush()
+    encoded := buffer.Bytes()
+
+    for _, source := range []io.RuneReader {bytes.NewReader(encoded), bufio.NewReader(bytes.NewReader(encoded))} {
+        reader := NewReader(source)
+        for _, expected := range records {
+            record, err := reader.ReadRaw()
+            if err != nil {
+                t.Fatal("error while reading raw DSV fields")
+            }
+            if fmt.Sprintf("%q", record) != fmt.Sprintf("%q", expected) {
+                t.Fatal(fmt.Sprintf("raw fields %q don't match written fields %q", record, expected))
+            }
+        }
+        if record, err := reader.ReadRaw(); record != nil || err != nil {
+            t.Fatal(fmt.Sprintf("ReadRaw returned %q, %v instead of nil, nil at the end of input", record, err))
+        }
+    }
+
+    record, err := NewReader(bytes.NewReader(encoded)).Read()
+    if err != nil {
+        t.Fatal("error while reading raw DSV fields")
+    }
+    if record[0] != "\uFFFD\uFFFD:\x00" {
+        t.Fatal(fmt.Sprintf("Read didn't replace invalid UTF-8 bytes: %q", record[0]))
+    }
+}
+
+func TestInvalidUTF8Modes(t *testing.T) {
+    input := []byte("ok:a\xffb\n")
+    tests := []struct {
+        mode            InvalidUTF8Mode
+        expectedField   string
+    } {
+        {InvalidUTF8Replace, "a\uFFFDb"},
+        {InvalidUTF8Passthrough, "a\xffb"},
+    }
+    for _, test := range tests {
+        reader := NewReader(bytes.NewReader(input))
+        reader.OnInvalidUTF8 = test.mode
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal(fmt.Sprintf("error while reading DSV with invalid UTF-8 in mode %v: %v", test.mode, err))
+        }
+        if len(record) != 2 || record[1] != test.expectedField {
+            t.Fatal(fmt.Sprintf("record %q doesn't have expected field %q in mode %v",
+                record, test.expectedField, test.mode))
+        }
+    }
+
+    reader := NewReader(bytes.NewReader(input))
+    reader.OnInvalidUTF8 = InvalidUTF8Error
+    _, err := reader.Read()
+    parseErr, ok := err.(*ParseError)
+    if !ok || parseErr.Err != ErrInvalidUTF8 {
+        t.Fatal(fmt.Sprintf("Read returned %v instead of a ParseError for invalid UTF-8", err))
+    }
+    if parseErr.Record != 1 || parseErr.Offset != 4 {
+        t.Fatal(fmt.Sprintf("ParseError %+v doesn't locate the invalid byte", parseErr))
+    }
+}
+
+func TestEscapeString(t *testing.T) {
+    input := "a^^:b:c^d^:e:^^^^^^\n^f^^\nlast^"
+    expectedOutput := [][]string {
+        {"a:b", "c^d^", "e", "^^"},
+        {"^f\nlast^"},
+    }
+    reader := NewReader(strings.NewReader(input))
+    reader.EscapeString = "^^"
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal("error while reading DSV with an escape prefix")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.SetDialect(reader.Dialect())
+    if err = writer.WriteAll(output); err != nil {
+        t.Fatal("error while writing DSV with an escape prefix")
+    }
+    expectedEncoding := "a^^:b:c^^^d^^^:e:^^^^^^\n^^^f^^\nlast^^^\n"
+    if buffer.String() != expectedEncoding {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedEncoding))
+    }
+    if EncodedLen(output[0], writer.Dialect()) + EncodedLen(output[1], writer.Dialect()) != buffer.Len() {
+        t.Fatal("EncodedLen doesn't account for the escape prefix")
+    }
+    reader = NewReader(&buffer)
+    reader.EscapeString = "^^"
+    if output, err = reader.ReadAll(); err != nil {
+        t.Fatal("error while reading DSV with an escape prefix")
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("records %q read back don't match written records %q", output, expectedOutput))
+    }
+}
+
+func TestDebugContext(t *testing.T) {
+    input := []byte("first:record\nsecond:record\nthird\t:bad\xff\x01byte:here\nlast\n")
+    reader := NewReader(bytes.NewReader(input))
+    reader.OnInvalidUTF8 = InvalidUTF8Error
+    reader.DebugContext = true
+    var err error
+    for i := 0; i < 3 && err == nil; i++ {
+        _, err = reader.Read()
+    }
+    parseErr, ok := err.(*ParseError)
+    if !ok || parseErr.Record != 3 || parseErr.Offset != 37 {
+        t.Fatal(fmt.Sprintf("Read returned %v instead of a ParseError for invalid UTF-8", err))
+    }
+    expectedContext := "d:record\nthird\t:bad\xff\x01byte:here\nlast\n"
+    if parseErr.Context != expectedContext {
+        t.Fatal(fmt.Sprintf("ParseError context %q doesn't match expected context %q", parseErr.Context, expectedContext))
+    }
+    expectedSnippet := `"d:record\nthird\t:bad\xff\x01byte:here\nlast\n"`
+    if !strings.Contains(err.Error(), expectedSnippet) {
+        t.Fatal(fmt.Sprintf("error %q doesn't contain context snippet %s", err.Error(), expectedSnippet))
+    }
+
+    // Reading ahead for the snippet must not consume input.
+    expectedOutput := [][]string {
+        {"\x01byte", "here"},
+        {"last"},
+    }
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading DSV after a ParseError: %v", err))
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+
+    reader = NewReader(bytes.NewReader(input))
+    reader.OnInvalidUTF8 = InvalidUTF8Error
+    for err == nil {
+        _, err = reader.Read()
+    }
+    if parseErr, ok = err.(*ParseError); !ok || parseErr.Context != "" {
+        t.Fatal(fmt.Sprintf("ParseError %v has context without DebugContext", err))
+    }
+}
+
+func TestWriteOrderedMap(t *testing.T) {
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    m := map[string]string {"name": "a:b", "id": "7", "unused": "x"}
+    if err := writer.WriteOrderedMap([]string {"id", "missing", "name"}, m); err != nil {
+        t.Fatal("error while writing a map as a DSV record")
+    }
+    if err := writer.WriteOrderedMap([]string {"name", "id"}, m); err != nil {
+        t.Fatal("error while writing a map as a DSV record")
+    }
+    writer.Flush()
+    if buffer.String() != "7::a\\:b\na\\:b:7\n" {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't follow the order of the keys", buffer.String()))
+    }
+}
+
+func TestWriteSorted(t *testing.T) {
+    records := [][]string {
+        {"b", "1"},
+        {"a", "2"},
+        {"c", "3"},
+        {"a", "4"},
+    }
+    original := fmt.Sprintf("%q", records)
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    err := writer.WriteSorted(records, func(a, b []string) bool {
+        return a[0] < b[0]
+    })
+    if err != nil {
+        t.Fatal("error while writing sorted DSV records")
+    }
+    if buffer.String() != "a:2\na:4\nb:1\nc:3\n" {
+        t.Fatal(fmt.Sprintf("written DSV %q isn't stably sorted", buffer.String()))
+    }
+    if fmt.Sprintf("%q", records) != original {
+        t.Fatal(fmt.Sprintf("WriteSorted reordered the caller's records to %q", records))
+    }
+}
+
+func TestWriteRecords(t *testing.T) {
+    records := [][]string {
+        {"a", "b:c"},
+        {"d\ne"},
+        {"f", "g", "h"},
+        {"i\\"},
+    }
+    expected := bytes.Buffer{}
+    if err := NewWriter(&expected).WriteAll(records); err != nil {
+        t.Fatal("error while writing DSV records")
+    }
+
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    for _, batch := range [][][]string {records[:1], records[1:3], records[3:]} {
+        if err := writer.WriteRecords(batch); err != nil {
+            t.Fatal("error while writing a batch of DSV records")
+        }
+    }
+    if buffer.Len() != 0 {
+        t.Fatal(fmt.Sprintf("WriteRecords flushed %q", buffer.String()))
+    }
+    writer.Flush()
+    if writer.Error() != nil {
+        t.Fatal("error while flushing DSV records")
+    }
+    if buffer.String() != expected.String() {
+        t.Fatal(fmt.Sprintf("batched output %q doesn't match WriteAll output %q", buffer.String(), expected.String()))
+    }
+}
+
+var wideRecord = strings.Repeat("x", 1 << 16) + ":" + strings.Repeat("y", 1 << 16) + "\n"
+
+func benchmarkReadWideField(b *testing.B, initialFieldCap int) {
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        reader := NewReader(strings.NewReader(wideRecord))
+        reader.InitialFieldCap = initialFieldCap
+        if _, err := reader.Read(); err != nil {
+            b.Fatal("error while reading DSV with wide fields")
+        }
+    }
+}
+
+func BenchmarkReadWideField(b *testing.B) {
+    benchmarkReadWideField(b, 0)
+}
+
+func BenchmarkReadWideFieldInitialCap(b *testing.B) {
+    benchmarkReadWideField(b, 1 << 16)
+}
+
+func TestSniff(t *testing.T) {
+    tests := []struct {
+        sample              string
+        expectedSeparator   rune
+        minConfidence       float64
+    } {
+        {"root:x:0:0:root:/root:/bin/sh\nbin:x:1:1:bin:/bin:/sbin/nologin\nnote:a\\:b, c:2:2::/:\n", ':', 0.9},
+        {"name,age,city\nalice,30,paris\nbob,25,new york: ny\ncarol,41,rome\n", ',', 0.7},
+        {"id\tname\tnote\n1\tx\ta, b\n2\ty\tc:d\n3\tz\te\n", '\t', 0.6},
+        {"partial,line", ',', 0.9},
+    }
+    for _, test := range tests {
+        d, confidence := Sniff([]byte(test.sample))
+        if d.Separator != test.expectedSeparator {
+            t.Fatal(fmt.Sprintf("Sniff chose separator %q instead of %q for %q", d.Separator, test.expectedSeparator, test.sample))
+        }
+        if confidence < test.minConfidence || confidence > 1 {
+            t.Fatal(fmt.Sprintf("Sniff reported confidence %v for %q", confidence, test.sample))
+        }
+        if d.Escape != '\\' || d.RecordSeparator != '\n' {
+            t.Fatal(fmt.Sprintf("Sniff returned unexpected dialect %+v", d))
+        }
+    }
+    if _, confidence := Sniff([]byte("plain\ntext\n")); confidence != 0 {
+        t.Fatal(fmt.Sprintf("Sniff reported confidence %v for input without separators", confidence))
+    }
+}
+
+func TestReaderQuote(t *testing.T) {
+    input := "\"a:b\":\"say \"\"hi\"\"\\\"x:\"\"\n\"multi\nline\":c\"d\"\n"
+    expectedOutput := [][]string {
+        {"a:b", "say \"hi\"\\x", ""},
+        {"multi\nline", "c\"d\""},
+    }
+    reader := NewReader(strings.NewReader(input))
+    reader.Quote = '"'
+    reader.KeepRaw = true
+    var output [][]string
+    var raw []string
+    for {
+        record, err := reader.Read()
+        if err != nil {
+            t.Fatal(fmt.Sprintf("error while reading quoted DSV: %v", err))
+        }
+        if record == nil {
+            break
+        }
+        output = append(output, record)
+        raw = append(raw, string(reader.RawRecord()))
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+    if strings.Join(raw, "\n") + "\n" != input {
+        t.Fatal(fmt.Sprintf("raw records %q don't match the input", raw))
+    }
+}
+
+func TestMaxQuotedFieldBytes(t *testing.T) {
+    input := "a:\"unterminated:b\nc:d\n\"ok\":e\n"
+    expectedOutput := [][]string {
+        {"a", "\"unterminated", "b"},
+        {"c", "d"},
+        {"ok", "e"},
+    }
+    var warnings []error
+    reader := NewReader(strings.NewReader(input))
+    reader.Quote = '"'
+    reader.MaxQuotedFieldBytes = 8
+    reader.OnWarning = func(err error) {
+        warnings = append(warnings, err)
+    }
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading DSV with an unterminated quote: %v", err))
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", expectedOutput) {
+        t.Fatal(fmt.Sprintf("output %q doesn't match expected output %q", output, expectedOutput))
+    }
+    if len(warnings) != 1 {
+        t.Fatal(fmt.Sprintf("Reader reported warnings %v instead of one", warnings))
+    }
+    parseErr, ok := warnings[0].(*ParseError)
+    if !ok || parseErr.Err != ErrUnterminatedQuote || parseErr.Record != 1 || parseErr.Offset != 2 {
+        t.Fatal(fmt.Sprintf("warning %v doesn't locate the unterminated quote", warnings[0]))
+    }
+
+    reader = NewReader(strings.NewReader(input))
+    reader.Quote = '"'
+    if output, err = reader.ReadAll(); err != nil {
+        t.Fatal(fmt.Sprintf("error while reading DSV with an unterminated quote: %v", err))
+    }
+    swallowed := [][]string {{"a", "unterminated:b\nc:d\nok\"", "e"}}
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", swallowed) {
+        t.Fatal(fmt.Sprintf("output %q without a limit doesn't match expected output %q", output, swallowed))
+    }
+}
+
+func TestMaxFieldBytes(t *testing.T) {
+    input := "a:b\nshort:ok:toolongfield:x\n"
+    for _, mode := range []string {"default", "quote", "split", "length"} {
+        reader := NewReader(strings.NewReader(input))
+        reader.MaxFieldBytes = 8
+        expectedOffset := int64(13)
+        switch mode {
+            case "quote":
+                reader = NewReader(strings.NewReader("a:b\nshort:ok:\"too:long\nfield\":x\n"))
+                reader.MaxFieldBytes = 8
+                reader.Quote = '"'
+            case "split":
+                reader.Split = bufio.ScanLines
+            case "length":
+                reader = NewReader(strings.NewReader("1:a1:b\n5:short2:ok13:toolongfield:1:x\n"))
+                reader.MaxFieldBytes = 8
+                reader.LengthPrefixed = true
+                expectedOffset = 18
+        }
+        record, err := reader.Read()
+        if err != nil || len(record) != 2 {
+            t.Fatal(fmt.Sprintf("Read returned %q and %v for a record with short fields in mode %v", record, err, mode))
+        }
+        _, err = reader.Read()
+        parseErr, ok := err.(*ParseError)
+        if !ok || parseErr.Err != ErrFieldTooLarge {
+            t.Fatal(fmt.Sprintf("Read returned %v instead of ErrFieldTooLarge in mode %v", err, mode))
+        }
+        if parseErr.Record != 2 || parseErr.Field != 3 || parseErr.Offset != expectedOffset {
+            t.Fatal(fmt.Sprintf("ParseError %+v doesn't locate the large field in mode %v", parseErr, mode))
+        }
+        if !strings.Contains(err.Error(), "record 2 field 3 at byte offset") {
+            t.Fatal(fmt.Sprintf("error %q doesn't mention the large field", err.Error()))
+        }
+    }
+}
+
+func TestEscapeNonASCII(t *testing.T) {
+    records := [][]string {
+        {"caf\u00e9", "na\u00efve:\U0001F600"},
+        {"plain u and U", "\u00c5ngstr\u00f6m\n"},
+    }
+    expectedOutput := "caf\\u00E9:na\\u00EFve\\:\\U0001F600\nplain u and U:\\u00C5ngstr\\u00F6m\\\n\n"
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.EscapeNonASCII = true
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal("error while writing DSV with escaped non-ASCII runes")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+
+    reader := NewReader(strings.NewReader(expectedOutput))
+    reader.UnicodeEscapes = true
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading DSV with Unicode escapes: %v", err))
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("records %q read back don't match written records %q", output, records))
+    }
+
+    reader = NewReader(strings.NewReader("caf\\u00e9:\\U0001F600\n"))
+    reader.UnicodeEscapes = true
+    reader.Split = bufio.ScanLines
+    record, err := reader.Read()
+    if err != nil || fmt.Sprintf("%q", record) != fmt.Sprintf("%q", []string {"caf\u00e9", "\U0001F600"}) {
+        t.Fatal(fmt.Sprintf("Read returned %q and %v for Unicode escapes with a custom splitter", record, err))
+    }
+
+    for _, split := range []bool {false, true} {
+        for _, input := range []string {"a\\u00G9\n", "a\\u00e\n", "a\\UFFFFFFFF\n"} {
+            reader = NewReader(strings.NewReader(input))
+            reader.UnicodeEscapes = true
+            if split {
+                reader.Split = bufio.ScanLines
+            }
+            _, err = reader.Read()
+            if parseErr, ok := err.(*ParseError); !ok || parseErr.Err != ErrInvalidUnicode {
+                t.Fatal(fmt.Sprintf("Read returned %v instead of ErrInvalidUnicode for %q", err, input))
+            }
+        }
+    }
+}
+
+func TestReadAllBytes(t *testing.T) {
+    input := "a:b\\:c\n\n:d\xff:\ne"
+    expected, err := NewReader(strings.NewReader(input)).ReadAll()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading DSV: %v", err))
+    }
+    records, err := NewReader(strings.NewReader(input)).ReadAllBytes()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading DSV as bytes: %v", err))
+    }
+    if len(records) != len(expected) {
+        t.Fatal(fmt.Sprintf("ReadAllBytes returned %q instead of %q", records, expected))
+    }
+    for n, record := range records {
+        if len(record) != len(expected[n]) {
+            t.Fatal(fmt.Sprintf("ReadAllBytes returned %q instead of %q", records, expected))
+        }
+        for m, field := range record {
+            if string(field) != expected[n][m] {
+                t.Fatal(fmt.Sprintf("ReadAllBytes returned %q instead of %q", records, expected))
+            }
+        }
+    }
+    records[0][0][0] = 'z'
+    if records[0][1][0] != 'b' || expected[0][0] != "a" {
+        t.Fatal("modifying a field returned by ReadAllBytes changed another field")
+    }
+}
+
+func TestNormalizeFieldNewlines(t *testing.T) {
+    records := [][]string {
+        {"a\r\nb\rc\nd", "\r\r\n"},
+    }
+    expectedOutput := "a\\\nb\\\nc\\\nd:\\\n\\\n\n"
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.NormalizeFieldNewlines = true
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal("error while writing DSV with normalized newlines")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+    if records[0][0] != "a\r\nb\rc\nd" {
+        t.Fatal("NormalizeFieldNewlines modified the caller's record")
+    }
+}
+
+type flushCounter struct {
+    writes      int
+}
+
+func (c *flushCounter) Write(p []byte) (int, error) {
+    c.writes++
+    return len(p), nil
+}
+
+func TestAutoFlushEvery(t *testing.T) {
+    counter := flushCounter{}
+    writer := NewWriter(&counter)
+    writer.AutoFlushEvery(10)
+    for n := 0; n < 25; n++ {
+        if err := writer.Write([]string {"a", strconv.Itoa(n)}); err != nil {
+            t.Fatal("error while writing DSV")
+        }
+    }
+    if counter.writes != 2 {
+        t.Fatal(fmt.Sprintf("Writer flushed %v times instead of 2 for 25 records", counter.writes))
+    }
+    writer.Flush()
+    if counter.writes != 3 {
+        t.Fatal(fmt.Sprintf("Writer flushed %v times instead of 3 after Flush", counter.writes))
+    }
+}
+
+func TestAutoFlushInterval(t *testing.T) {
+    counter := flushCounter{}
+    clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+    writer := NewWriter(&counter)
+    writer.now = func() time.Time { return clock }
+    writer.AutoFlushInterval(time.Second)
+    for _, step := range []struct {
+        elapsed time.Duration
+        writes  int
+    } {
+        {0, 0},
+        {500 * time.Millisecond, 0},
+        {500 * time.Millisecond, 1},
+        {999 * time.Millisecond, 1},
+        {time.Hour, 2},
+    } {
+        clock = clock.Add(step.elapsed)
+        if err := writer.Write([]string {"a"}); err != nil {
+            t.Fatal("error while writing DSV")
+        }
+        if counter.writes != step.writes {
+            t.Fatal(fmt.Sprintf("Writer flushed %v times instead of %v at %v", counter.writes, step.writes, clock))
+        }
+    }
+}
+
+func TestWriteFieldFastPath(t *testing.T) {
+    fields := []string {
+        "", "plain", "caf\u00e9", "a:b", "a\\b", "a\nb", "a\rb", "a|b", "\xffx", "x\xc3", "\uFFFD",
+    }
+    for _, raw := range []bool {false, true} {
+        for _, configure := range []func(w *Writer) {
+            func(w *Writer) {},
+            func(w *Writer) { w.EscapeNonASCII = true },
+            func(w *Writer) { w.WindowsLineEndings = true },
+            func(w *Writer) { w.ProtectRune('|') },
+            func(w *Writer) { w.EscapeString = "\\\\" },
+        } {
+            for _, literalSeparators := range []bool {false, true} {
+                for _, field := range fields {
+                    fast, slow := bytes.Buffer{}, bytes.Buffer{}
+                    fastWriter, slowWriter := NewWriter(&fast), NewWriter(&slow)
+                    configure(fastWriter)
+                    configure(slowWriter)
+                    escape := slowWriter.Dialect().escapeRune()
+                    if fastWriter.writeField(field, raw, literalSeparators) != nil ||
+                            slowWriter.writeEscapedField(field, escape, raw, literalSeparators) != nil {
+                        t.Fatal("error while writing DSV field")
+                    }
+                    fastWriter.Flush()
+                    slowWriter.Flush()
+                    if fast.String() != slow.String() {
+                        t.Fatal(fmt.Sprintf("writeField wrote %q instead of %q for %q", fast.String(), slow.String(), field))
+                    }
+                }
+            }
+        }
+    }
+}
+
+func benchmarkWriteFields(b *testing.B, field string) {
+    record := []string {field, field, field, field}
+    b.ReportAllocs()
+    writer := NewWriter(io.Discard)
+    for i := 0; i < b.N; i++ {
+        if err := writer.Write(record); err != nil {
+            b.Fatal("error while writing DSV")
+        }
+    }
+    writer.Flush()
+}
+
+func BenchmarkWritePlainFields(b *testing.B) {
+    benchmarkWriteFields(b, strings.Repeat("plain text ", 8))
+}
+
+func BenchmarkWriteEscapedFields(b *testing.B) {
+    benchmarkWriteFields(b, strings.Repeat("a:b\\c ", 8))
+}
+
+func TestLint(t *testing.T) {
+    input := "a:b:c\nd:e\nf:ghijklmnop:q\nr:\\u00G9:s\nt:\xff:u\nv:w:x\\"
+    reader := NewReader(strings.NewReader(input))
+    reader.FieldsPerRecord = 3
+    reader.MaxFieldBytes = 8
+    reader.UnicodeEscapes = true
+    reader.OnInvalidUTF8 = InvalidUTF8Error
+    warnings := 0
+    reader.OnWarning = func(error) {
+        warnings++
+    }
+    problems, err := reader.Lint()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("Lint returned an error: %v", err))
+    }
+    expected := []ParseError {
+        {Record: 2, Field: 1, Offset: 6, Err: ErrFieldCount},
+        {Record: 3, Field: 2, Offset: 12, Err: ErrFieldTooLarge},
+        {Record: 4, Field: 2, Offset: 28, Err: ErrInvalidUnicode},
+        {Record: 5, Field: 2, Offset: 38, Err: ErrInvalidUTF8},
+        {Record: 6, Field: 3, Offset: 47, Err: ErrDanglingEscape},
+    }
+    if fmt.Sprintf("%v", problems) != fmt.Sprintf("%v", expected) {
+        t.Fatal(fmt.Sprintf("Lint found problems %v instead of %v", problems, expected))
+    }
+    if warnings != 1 {
+        t.Fatal(fmt.Sprintf("Lint reported %v warnings to OnWarning instead of 1", warnings))
+    }
+
+    problems, err = Lint(strings.NewReader("a:b\nc\\"), reader.Dialect())
+    if err != nil || len(problems) != 1 || problems[0].Err != ErrDanglingEscape {
+        t.Fatal(fmt.Sprintf("Lint returned %v, %v instead of a dangling escape", problems, err))
+    }
+}
+
+func TestQuoteField(t *testing.T) {
+    tests := []struct {
+        field               string
+        expectedCSV         string
+        expectedShell       string
+    } {
+        {"", "", "''"},
+        {"plain", "plain", "plain"},
+        {"a/b-c.d:e", "a/b-c.d:e", "a/b-c.d:e"},
+        {"two words", "two words", "'two words'"},
+        {" padded ", "\" padded \"", "' padded '"},
+        {"a,b", "\"a,b\"", "a,b"},
+        {"say \"hi\"", "\"say \"\"hi\"\"\"", "'say \"hi\"'"},
+        {"it's", "it's", "'it'\\''s'"},
+        {"line\nbreak", "\"line\nbreak\"", "'line\nbreak'"},
+        {"$HOME \\ `x`", "$HOME \\ `x`", "'$HOME \\ `x`'"},
+        {"caf\u00e9", "caf\u00e9", "'caf\u00e9'"},
+    }
+    for _, test := range tests {
+        if quoted := CSVQuoteField(test.field); quoted != test.expectedCSV {
+            t.Fatal(fmt.Sprintf("CSVQuoteField returned %q instead of %q for %q", quoted, test.expectedCSV, test.field))
+        }
+        if quoted := ShellQuoteField(test.field); quoted != test.expectedShell {
+            t.Fatal(fmt.Sprintf("ShellQuoteField returned %q instead of %q for %q", quoted, test.expectedShell, test.field))
+        }
+    }
+}
+
+func TestNextField(t *testing.T) {
+    input := "\na:b\\:c:\n\nd\\\ne:\"f:g\"\n:\nh"
+    reader := NewReader(strings.NewReader(input))
+    reader.Quote = '"'
+    expected, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading DSV: %v", err))
+    }
+
+    reader = NewReader(strings.NewReader(input))
+    reader.Quote = '"'
+    var records [][]string
+    var record []string
+    for {
+        field, endOfRecord, err := reader.NextField()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(fmt.Sprintf("error while reading DSV fields: %v", err))
+        }
+        record = append(record, field)
+        if endOfRecord {
+            records = append(records, record)
+            record = nil
+        }
+    }
+    if record != nil {
+        t.Fatal(fmt.Sprintf("NextField didn't end the last record %q", record))
+    }
+    if fmt.Sprintf("%q", records) != fmt.Sprintf("%q", expected) {
+        t.Fatal(fmt.Sprintf("records %q from NextField don't match records %q from Read", records, expected))
+    }
+
+    reader.LengthPrefixed = true
+    if _, _, err = reader.NextField(); err != ErrUnsupportedFraming {
+        t.Fatal(fmt.Sprintf("NextField returned %v instead of ErrUnsupportedFraming", err))
+    }
+}
+
+func TestSetColumnOrder(t *testing.T) {
+    reader := NewReader(strings.NewReader("a:b:c\nd:e:f:g\nh:i\n"))
+    reader.SetColumnOrder([]int {2, 0, 1})
+    for _, expected := range [][]string {{"c", "a", "b"}, {"f", "d", "e"}} {
+        record, err := reader.Read()
+        if err != nil || fmt.Sprintf("%q", record) != fmt.Sprintf("%q", expected) {
+            t.Fatal(fmt.Sprintf("Read returned %q, %v instead of %q", record, err, expected))
+        }
+    }
+    if record, err := reader.Read(); err != ErrColumnIndex || fmt.Sprintf("%q", record) != `["h" "i"]` {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v instead of ErrColumnIndex", record, err))
+    }
+
+    reader = NewReader(strings.NewReader("a:b\n"))
+    reader.SetColumnOrder([]int {1, -1})
+    if _, err := reader.Read(); err != ErrColumnIndex {
+        t.Fatal(fmt.Sprintf("Read returned %v instead of ErrColumnIndex for a negative index", err))
+    }
+}
+
+func TestPadShortRecords(t *testing.T) {
+    for _, pad := range []bool {false, true} {
+        reader := NewReader(strings.NewReader("a:b:c\nd\n"))
+        reader.SetColumnOrder([]int {2, 0})
+        reader.PadShortRecords = pad
+        if record, err := reader.Read(); err != nil || fmt.Sprintf("%q", record) != `["c" "a"]` {
+            t.Fatal(fmt.Sprintf("Read returned %q, %v for a record long enough for the column order", record, err))
+        }
+        record, err := reader.Read()
+        if pad && (err != nil || fmt.Sprintf("%q", record) != `["" "d"]`) {
+            t.Fatal(fmt.Sprintf("Read returned %q, %v instead of a padded short record", record, err))
+        }
+        if !pad && err != ErrColumnIndex {
+            t.Fatal(fmt.Sprintf("Read returned %q, %v instead of ErrColumnIndex for a short record", record, err))
+        }
+    }
+
+    reader := NewReader(strings.NewReader("a\n"))
+    reader.SetColumnOrder([]int {-1})
+    reader.PadShortRecords = true
+    if _, err := reader.Read(); err != ErrColumnIndex {
+        t.Fatal(fmt.Sprintf("Read returned %v instead of ErrColumnIndex for a negative index", err))
+    }
+}
+
+func TestBase64Fields(t *testing.T) {
+    random := rand.New(rand.NewSource(1))
+    var records [][][]byte
+    for n := 0; n < 20; n++ {
+        blob := make([]byte, random.Intn(200))
+        random.Read(blob)
+        records = append(records, [][]byte {[]byte("blob " + strconv.Itoa(n)), blob})
+    }
+    isBinary := func(index int) bool {
+        return index == 1
+    }
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.Base64Fields = isBinary
+    for _, record := range records {
+        if err := writer.WriteRaw(record); err != nil {
+            t.Fatal(fmt.Sprintf("error while writing base64 fields: %v", err))
+        }
+    }
+    writer.Flush()
+    if strings.ContainsRune(buffer.String(), '\\') {
+        t.Fatal("base64 fields were escaped")
+    }
+
+    reader := NewReader(strings.NewReader(buffer.String()))
+    reader.Base64Fields = isBinary
+    for _, expected := range records {
+        record, err := reader.ReadRaw()
+        if err != nil {
+            t.Fatal(fmt.Sprintf("error while reading base64 fields: %v", err))
+        }
+        if fmt.Sprintf("%q", record) != fmt.Sprintf("%q", expected) {
+            t.Fatal(fmt.Sprintf("decoded fields %q don't match written fields %q", record, expected))
+        }
+    }
+
+    reader = NewReader(strings.NewReader("a:not base64!\n"))
+    reader.Base64Fields = isBinary
+    if _, err := reader.Read(); err == nil {
+        t.Fatal("Read accepted an invalid base64 field")
+    } else if parseErr, ok := err.(*ParseError); !ok || parseErr.Field != 2 {
+        t.Fatal(fmt.Sprintf("Read returned %v instead of a ParseError for field 2", err))
+    }
+}
+
+func TestTSV(t *testing.T) {
+    records := [][]string {
+        {"id", "note", ""},
+        {"1", "tab\there", "back\\slash"},
+        {"2", "line\nbreak\t", "colon: fine"},
+    }
+    expectedOutput := "id\tnote\t\n1\ttab\\\there\tback\\\\slash\n2\tline\\\nbreak\\\t\tcolon: fine\n"
+    buffer := bytes.Buffer{}
+    writer := NewTSVWriter(&buffer)
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal(fmt.Sprintf("error while writing TSV: %v", err))
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written TSV %q doesn't match expected TSV %q", buffer.String(), expectedOutput))
+    }
+    output, err := NewTSVReader(strings.NewReader(buffer.String())).ReadAll()
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while reading TSV: %v", err))
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("records %q read back don't match written records %q", output, records))
+    }
+}
+
+func TestDedupByField(t *testing.T) {
+    records := [][]string {
+        {"1", "a"},
+        {"2", "a"},
+        {"3", "b"},
+        {"4", "b"},
+        {"5"},
+        {"6", "b"},
+        {"7", "c"},
+        {"8", "b"},
+    }
+    expectedOutput := "1:a\n3:b\n5\n6:b\n7:c\n8:b\n"
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.DedupByField(1)
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal("error while writing DSV")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+}
+
+func TestCollapseInnerWhitespace(t *testing.T) {
+    input := "a   b:c \t d:e\\ \\  f: g :\"h  i\"\n"
+    expected := []string {"a b", "c d", "e   f", " g ", "h  i"}
+    for _, split := range []bool {false, true} {
+        reader := NewReader(strings.NewReader(input))
+        reader.CollapseInnerWhitespace = true
+        reader.Quote = '"'
+        if split {
+            reader.Split = bufio.ScanLines
+            expected[4] = "\"h i\""
+        }
+        record, err := reader.Read()
+        if err != nil || fmt.Sprintf("%q", record) != fmt.Sprintf("%q", expected) {
+            t.Fatal(fmt.Sprintf("Read returned %q, %v instead of %q", record, err, expected))
+        }
+    }
+    record, err := NewReader(strings.NewReader("a   b\n")).Read()
+    if err != nil || record[0] != "a   b" {
+        t.Fatal(fmt.Sprintf("Read collapsed whitespace by default: %q", record))
+    }
+}
+
+func TestWriteWithSeparator(t *testing.T) {
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    if err := writer.Write([]string {"user", "ada", "a;b"}); err != nil {
+        t.Fatal("error while writing DSV")
+    }
+    if err := writer.WriteWithSeparator([]string {"group", "admins:ops", "x;y"}, ';'); err != nil {
+        t.Fatal("error while writing DSV with a custom separator")
+    }
+    if err := writer.Write([]string {"user", "b:c"}); err != nil {
+        t.Fatal("error while writing DSV")
+    }
+    if err := writer.WriteWithSeparator([]string {"bad"}, '\n'); err != ErrRecordSeparatorConflict {
+        t.Fatal(fmt.Sprintf("WriteWithSeparator returned %v instead of ErrRecordSeparatorConflict", err))
+    }
+    writer.Flush()
+    expectedOutput := "user:ada:a;b\ngroup;admins:ops;x\\;y\nuser:b\\:c\n"
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+
+    expectedRecords := [][]string {{"user", "ada", "a;b"}, {"group", "admins:ops", "x;y"}, {"user", "b:c"}}
+    for n, line := range strings.SplitAfter(strings.TrimSuffix(buffer.String(), "\n"), "\n") {
+        d := Dialect {Escape: '\\', Separator: ':', RecordSeparator: '\n'}
+        if strings.HasPrefix(line, "group") {
+            d.Separator = ';'
+        }
+        record, err := ParseRecord(line, d)
+        if err != nil || fmt.Sprintf("%q", record) != fmt.Sprintf("%q", expectedRecords[n]) {
+            t.Fatal(fmt.Sprintf("ParseRecord returned %q, %v instead of %q", record, err, expectedRecords[n]))
+        }
+    }
+}
+
+func TestRawFields(t *testing.T) {
+    input := "a\\:b:\\\\\\n\\x:\\\nc:\"q:\"\"\":\n\nd::e\\"
+    expectedFields := [][]string {
+        {"a:b", "\\nx", "\nc", "q:\"", ""},
+        {"d", "", "e"},
+    }
+    expectedRaw := [][]string {
+        {"a\\:b", "\\\\\\n\\x", "\\\nc", "\"q:\"\"\"", ""},
+        {"d", "", "e\\"},
+    }
+    for _, split := range []bool {false, true} {
+        reader := NewReader(strings.NewReader(input))
+        reader.KeepRawFields = true
+        reader.Quote = '"'
+        if split {
+            reader.Split = func(data []byte, atEOF bool) (int, []byte, error) {
+                if i := bytes.Index(data, []byte(":\n\n")); i >= 0 {
+                    return i + 3, data[:i + 1], nil
+                }
+                if atEOF && len(data) > 0 {
+                    return len(data), data, nil
+                }
+                return 0, nil, nil
+            }
+            expectedFields[0][3], expectedFields[0][4] = "\"q", "\"\"\""
+            expectedRaw[0][3], expectedRaw[0][4] = "\"q", "\"\"\""
+            expectedFields[0] = append(expectedFields[0], "")
+            expectedRaw[0] = append(expectedRaw[0], "")
+        }
+        for n := range expectedFields {
+            record, err := reader.Read()
+            if err != nil || fmt.Sprintf("%q", record) != fmt.Sprintf("%q", expectedFields[n]) {
+                t.Fatal(fmt.Sprintf("Read returned %q, %v instead of %q", record, err, expectedFields[n]))
+            }
+            if raw := reader.RawFields(); fmt.Sprintf("%q", raw) != fmt.Sprintf("%q", expectedRaw[n]) {
+                t.Fatal(fmt.Sprintf("raw fields %q don't match expected raw fields %q", raw, expectedRaw[n]))
+            }
+        }
+    }
+    if NewReader(strings.NewReader(input)).RawFields() != nil {
+        t.Fatal("RawFields returned raw fields without KeepRawFields")
+    }
+}
+
+func TestLimitFields(t *testing.T) {
+    input := "a:b:c\\\nd:\"e\nf\"\"\":g\nh\ni:j:\"k\n\":l\n"
+    expected := [][]string {{"a", "b"}, {"h"}, {"i", "j"}}
+    reader := NewReader(strings.NewReader(input))
+    reader.Quote = '"'
+    reader.LimitFields(2)
+    records, err := reader.ReadAll()
+    if err != nil || fmt.Sprintf("%q", records) != fmt.Sprintf("%q", expected) {
+        t.Fatal(fmt.Sprintf("ReadAll returned %q, %v instead of %q", records, err, expected))
+    }
+
+    reader = NewReader(strings.NewReader("a:b:c\nd:e:f\n"))
+    reader.Split = bufio.ScanLines
+    reader.LimitFields(2)
+    records, err = reader.ReadAll()
+    if err != nil || fmt.Sprintf("%q", records) != `[["a" "b"] ["d" "e"]]` {
+        t.Fatal(fmt.Sprintf("ReadAll returned %q, %v with Split", records, err))
+    }
+}
+
+var wideRecords = strings.Repeat("key:value" + strings.Repeat(":some\\:escaped field", 200) + "\n", 100)
+
+func benchmarkReadWideRecords(b *testing.B, limit int) {
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        reader := NewReader(strings.NewReader(wideRecords))
+        reader.LimitFields(limit)
+        if _, err := reader.ReadAll(); err != nil {
+            b.Fatal("error while reading DSV with wide records")
+        }
+    }
+}
+
+func BenchmarkReadWideRecords(b *testing.B) {
+    benchmarkReadWideRecords(b, 0)
+}
+
+func BenchmarkReadWideRecordsLimitFields(b *testing.B) {
+    benchmarkReadWideRecords(b, 2)
+}
+
+func TestRejectNUL(t *testing.T) {
+    reader := NewReader(strings.NewReader("a:b\nc:d\x00e\nf\n"))
+    reader.RejectNUL = true
+    if record, err := reader.Read(); err != nil || fmt.Sprintf("%q", record) != `["a" "b"]` {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v for a record without NUL bytes", record, err))
+    }
+    _, err := reader.Read()
+    if parseErr, ok := err.(*ParseError); !ok || parseErr.Err != ErrNUL || parseErr.Record != 2 || parseErr.Field != 2 {
+        t.Fatal(fmt.Sprintf("Read returned %v instead of ErrNUL in record 2 field 2", err))
+    }
+    if record, err := reader.Read(); err != nil || fmt.Sprintf("%q", record) != `["f"]` {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v after rejecting a record", record, err))
+    }
+
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    writer.RejectNUL = true
+    if err = writer.Write([]string {"a", "b"}); err != nil {
+        t.Fatal("error while writing DSV")
+    }
+    err = writer.Write([]string {"c", "d", "e\x00"})
+    if writeErr, ok := err.(*WriteError); !ok || writeErr.Err != ErrNUL || writeErr.Record != 2 || writeErr.Field != 3 {
+        t.Fatal(fmt.Sprintf("Write returned %v instead of ErrNUL in record 2 field 3", err))
+    }
+    writer.Flush()
+    if buffer.String() != "a:b\n" {
+        t.Fatal(fmt.Sprintf("Writer wrote %q despite rejecting a record", buffer.String()))
+    }
+}
+
+func TestReadMap(t *testing.T) {
+    reader := NewReader(strings.NewReader("name:age\nAda:36\nGrace\nAlan:41:x\n"))
+    if _, err := reader.ReadMap(); err != ErrNoHeader {
+        t.Fatal(fmt.Sprintf("ReadMap returned %v instead of ErrNoHeader", err))
+    }
+    if header, err := reader.ReadHeader(); err != nil || fmt.Sprintf("%q", header) != `["name" "age"]` {
+        t.Fatal(fmt.Sprintf("ReadHeader returned %q, %v", header, err))
+    }
+    for _, expected := range []string {"map[age:36 name:Ada]", "map[name:Grace]", "map[age:41 name:Alan]"} {
+        m, err := reader.ReadMap()
+        if err != nil || fmt.Sprint(m) != expected {
+            t.Fatal(fmt.Sprintf("ReadMap returned %v, %v instead of %v", m, err, expected))
+        }
+    }
+    if m, err := reader.ReadMap(); m != nil || err != nil {
+        t.Fatal(fmt.Sprintf("ReadMap returned %v, %v at the end of input", m, err))
+    }
+}
+
+func TestReadMapPadShortRecords(t *testing.T) {
+    for _, pad := range []bool {false, true} {
+        reader := NewReader(strings.NewReader("name:age:city\nGrace\n"))
+        reader.PadShortRecords = pad
+        if _, err := reader.ReadHeader(); err != nil {
+            t.Fatal(err)
+        }
+        expected := "map[name:Grace]"
+        if pad {
+            expected = "map[age: city: name:Grace]"
+        }
+        if m, err := reader.ReadMap(); err != nil || fmt.Sprint(m) != expected {
+            t.Fatal(fmt.Sprintf("ReadMap returned %v, %v instead of %v", m, err, expected))
+        }
+    }
+}
+
+func TestReadMapHeaderNormalize(t *testing.T) {
+    reader := NewReader(strings.NewReader("Name:AGE\nAda:36\n"))
+    reader.HeaderNormalize = strings.ToLower
+    if header, err := reader.ReadHeader(); err != nil || fmt.Sprintf("%q", header) != `["Name" "AGE"]` {
+        t.Fatal(fmt.Sprintf("ReadHeader returned %q, %v", header, err))
+    }
+    if m, err := reader.ReadMap(); err != nil || fmt.Sprint(m) != "map[age:36 name:Ada]" {
+        t.Fatal(fmt.Sprintf("ReadMap returned %v, %v with normalized header names", m, err))
+    }
+}
+
+func TestGenerateColumnNames(t *testing.T) {
+    reader := NewReader(strings.NewReader("Ada:36\nGrace:85:x\n"))
+    reader.GenerateColumnNames = true
+    for _, expected := range []string {"map[col0:Ada col1:36]", "map[col0:Grace col1:85 col2:x]"} {
+        m, err := reader.ReadMap()
+        if err != nil || fmt.Sprint(m) != expected {
+            t.Fatal(fmt.Sprintf("ReadMap returned %v, %v instead of %v", m, err, expected))
+        }
+    }
+}
+
+func TestResolveEscape(t *testing.T) {
+    reader := NewReader(strings.NewReader("a\\:b:c\\d\\\\e\n"))
+    reader.ResolveEscape = func(next rune) (rune, bool, bool) {
+        switch next {
+            case ':':
+                return -1, true, true
+            case '\\':
+                return -1, false, false
+            default:
+                return '\\', false, false
+        }
+    }
+    record, err := reader.Read()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := fmt.Sprintf("%q", record); s != `["a" "b" "c\\d\\e"]` {
+        t.Fatal(fmt.Sprintf("Read returned %s", s))
+    }
+}
+
+func TestOpenAppendWriter(t *testing.T) {
+    d := NewReader(strings.NewReader("")).Dialect()
+    for _, test := range []struct {
+        existing, expected string
+    } {
+        {"", "c:d\n"},
+        {"a:b\n", "a:b\nc:d\n"},
+        {"a:b", "a:b\nc:d\n"},
+    } {
+        path := filepath.Join(t.TempDir(), "log.dsv")
+        if test.existing != "" {
+            if err := os.WriteFile(path, []byte(test.existing), 0666); err != nil {
+                t.Fatal(err)
+            }
+        }
+        writer, err := OpenAppendWriter(path, d)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err = writer.Write([]string {"c", "d"}); err != nil {
+            t.Fatal(err)
+        }
+        if err = writer.Close(); err != nil {
+            t.Fatal(err)
+        }
+        data, err := os.ReadFile(path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(data) != test.expected {
+            t.Fatal(fmt.Sprintf("appending to %q produced %q instead of %q", test.existing, data, test.expected))
+        }
+    }
+}
+
+func TestSchemaHeader(t *testing.T) {
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.Separator = '\t'
+    writer.Escape = '%'
+    if err := writer.WriteSchemaHeader(); err != nil {
+        t.Fatal(err)
+    }
+    if err := writer.Write([]string {"a\tb", "c"}); err != nil {
+        t.Fatal(err)
+    }
+    writer.Flush()
+    if b.String() != "#dsv v1 sep=U+0009 esc=%\na%\tb\tc\n" {
+        t.Fatal(fmt.Sprintf("WriteSchemaHeader produced %q", b.String()))
+    }
+    reader := NewReader(strings.NewReader(b.String()))
+    d, err := reader.ReadSchemaHeader()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if d.Separator != '\t' || d.Escape != '%' {
+        t.Fatal(fmt.Sprintf("ReadSchemaHeader returned separator %q and escape %q", d.Separator, d.Escape))
+    }
+    record, err := reader.Read()
+    if err != nil || fmt.Sprintf("%q", record) != `["a\tb" "c"]` {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v", record, err))
+    }
+
+    reader = NewReader(strings.NewReader("a:b\n"))
+    if _, err = reader.ReadSchemaHeader(); err != ErrNoSchemaHeader {
+        t.Fatal(fmt.Sprintf("ReadSchemaHeader returned %v instead of ErrNoSchemaHeader", err))
+    }
+    if record, err = reader.Read(); err != nil || fmt.Sprintf("%q", record) != `["a" "b"]` {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v", record, err))
+    }
+    reader = NewReader(strings.NewReader("#dsv v2 sep=;\n"))
+    if _, err = reader.ReadSchemaHeader(); err != ErrSchemaHeader {
+        t.Fatal(fmt.Sprintf("ReadSchemaHeader returned %v instead of ErrSchemaHeader", err))
+    }
+}
+
+func TestMergeSorted(t *testing.T) {
+    byFirstField := func(x, y []string) bool {
+        return x[0] < y[0]
+    }
+    for _, test := range []struct {
+        a, b, expected string
+    } {
+        {"a:1\nc:1\nd:1\n", "b:2\nc:2\ne:2\nf:2\ng:2\n", "a:1\nb:2\nc:1\nc:2\nd:1\ne:2\nf:2\ng:2\n"},
+        {"b:1\nc:1\n", "a:2\n", "a:2\nb:1\nc:1\n"},
+        {"", "a:2\nb:2\n", "a:2\nb:2\n"},
+        {"", "", ""},
+    } {
+        var b bytes.Buffer
+        err := MergeSorted(NewWriter(&b), NewReader(strings.NewReader(test.a)), NewReader(strings.NewReader(test.b)), byFirstField)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if b.String() != test.expected {
+            t.Fatal(fmt.Sprintf("merging %q and %q produced %q instead of %q", test.a, test.b, b.String(), test.expected))
+        }
+    }
+}
+
+func TestComment(t *testing.T) {
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.Comment = '#'
+    if err := writer.WriteAll([][]string {{"#1", "a#"}, {"b", "#2"}}); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != "\\#1:a#\nb:#2\n" {
+        t.Fatal(fmt.Sprintf("Writer with Comment produced %q", b.String()))
+    }
+    reader := NewReader(strings.NewReader("# a comment\n" + b.String() + "\n#another:comment\nc\n# final comment"))
+    reader.Comment = '#'
+    records, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := fmt.Sprintf("%q", records); s != `[["#1" "a#"] ["b" "#2"] ["c"]]` {
+        t.Fatal(fmt.Sprintf("Reader with Comment read %s", s))
+    }
+}
+
+func TestRecordContinuation(t *testing.T) {
+    reader := NewReader(strings.NewReader("a:b+\nc:d+\n\ne\nf:g\n"))
+    reader.KeepRawFields = true
+    reader.RecordContinuation = func(lastField string) bool {
+        return strings.HasSuffix(lastField, "+")
+    }
+    record, err := reader.Read()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := fmt.Sprintf("%q", record); s != `["a" "b+" "c" "d+" "e"]` {
+        t.Fatal(fmt.Sprintf("Read returned %s for a continued record", s))
+    }
+    if offset, lines := reader.RecordOffset(), reader.PhysicalLines(); offset != 0 || lines != 3 {
+        t.Fatal(fmt.Sprintf("continued record has offset %v and %v physical lines instead of 0 and 3", offset, lines))
+    }
+    if s := fmt.Sprintf("%q", reader.RawFields()); s != `["a" "b+" "c" "d+" "e"]` {
+        t.Fatal(fmt.Sprintf("RawFields returned %s for a continued record", s))
+    }
+    if record, err = reader.Read(); err != nil || fmt.Sprintf("%q", record) != `["f" "g"]` {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v after a continued record", record, err))
+    }
+    if offset := reader.RecordOffset(); offset != 13 {
+        t.Fatal(fmt.Sprintf("record after a continued record has offset %v instead of 13", offset))
+    }
+}
+
+func TestRecordContinuationRawRecord(t *testing.T) {
+    reader := NewReader(strings.NewReader("a:b+\nc\\:d+\n\ne\nf:g\n"))
+    reader.KeepRaw = true
+    reader.RecordContinuation = func(lastField string) bool {
+        return strings.HasSuffix(lastField, "+")
+    }
+    if record, err := reader.Read(); err != nil || len(record) != 4 {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v for a continued record", record, err))
+    }
+    if raw := string(reader.RawRecord()); raw != "a:b+\nc\\:d+\ne" {
+        t.Fatal(fmt.Sprintf("RawRecord returned %q for a continued record", raw))
+    }
+    if _, err := reader.Read(); err != nil || string(reader.RawRecord()) != "f:g" {
+        t.Fatal(fmt.Sprintf("RawRecord returned %q, %v after a continued record", reader.RawRecord(), err))
+    }
+}
+
+func TestWriteEmptyRecords(t *testing.T) {
+    for _, test := range []struct {
+        record []string
+        name   string
+    } {
+        {nil, "nil"},
+        {[]string {}, "empty"},
+        {[]string {""}, "single empty field"},
+    } {
+        var b bytes.Buffer
+        writer := NewWriter(&b)
+        if err := writer.WriteAll([][]string {{"a"}, test.record, {"b"}}); err != nil {
+            t.Fatal(err)
+        }
+        if b.String() != "a\n\nb\n" {
+            t.Fatal(fmt.Sprintf("writing a %s record produced %q", test.name, b.String()))
+        }
+        records, err := NewReader(strings.NewReader(b.String())).ReadAll()
+        if err != nil || fmt.Sprintf("%q", records) != `[["a"] ["b"]]` {
+            t.Fatal(fmt.Sprintf("reading a %s record returned %q, %v", test.name, records, err))
+        }
+        reader := NewReader(strings.NewReader(b.String()))
+        reader.PreserveEmptyRecords = true
+        records, err = reader.ReadAll()
+        if err != nil || fmt.Sprintf("%q", records) != `[["a"] [""] ["b"]]` {
+            t.Fatal(fmt.Sprintf("reading a %s record with PreserveEmptyRecords returned %q, %v", test.name, records, err))
+        }
+    }
+}
+
+func TestQuoteEmptyFields(t *testing.T) {
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.Quote = '"'
+    writer.QuoteEmptyFields = true
+    if err := writer.WriteAll([][]string {{"a", ""}, {""}, {}, nil, {"", "b"}}); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != "a:\"\"\n\"\"\n\"\":b\n" {
+        t.Fatal(fmt.Sprintf("Writer with QuoteEmptyFields produced %q", b.String()))
+    }
+    reader := NewReader(strings.NewReader(b.String()))
+    reader.Quote = '"'
+    records, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := fmt.Sprintf("%q", records); s != `[["a" ""] [""] ["" "b"]]` {
+        t.Fatal(fmt.Sprintf("Reader read %s", s))
+    }
+}
+
+func TestReadColumns(t *testing.T) {
+    columns, header, err := ReadColumns(strings.NewReader("name:age\nAda:36\nGrace:85\nAlan:41\n"), NewReader(nil).Dialect(), true, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := fmt.Sprintf("%q %q", header, columns); s != `["name" "age"] [["Ada" "Grace" "Alan"] ["36" "85" "41"]]` {
+        t.Fatal(fmt.Sprintf("ReadColumns returned %s", s))
+    }
+
+    input := "a:b\nc\nd:e:f\n"
+    columns, header, err = ReadColumns(strings.NewReader(input), NewReader(nil).Dialect(), false, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := fmt.Sprintf("%q %q", header, columns); s != `[] [["a" "c" "d"] ["b" "" "e"] ["" "" "f"]]` {
+        t.Fatal(fmt.Sprintf("ReadColumns returned %s for ragged records with padding", s))
+    }
+    columns, _, err = ReadColumns(strings.NewReader(input), NewReader(nil).Dialect(), false, false)
+    if parseErr, ok := err.(*ParseError); !ok || parseErr.Err != ErrFieldCount || parseErr.Record != 2 {
+        t.Fatal(fmt.Sprintf("ReadColumns returned %v instead of ErrFieldCount for record 2", err))
+    }
+    if s := fmt.Sprintf("%q", columns); s != `[["a"] ["b"]]` {
+        t.Fatal(fmt.Sprintf("ReadColumns returned %s before an error", s))
+    }
+}
+
+func TestWriteColumns(t *testing.T) {
+    input := "name:age\nAda:36\nGr\\:ace:85\n"
+    d := NewReader(nil).Dialect()
+    columns, header, err := ReadColumns(strings.NewReader(input), d, true, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var b bytes.Buffer
+    if err = WriteColumns(&b, columns, header, d); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != input {
+        t.Fatal(fmt.Sprintf("WriteColumns produced %q instead of %q", b.String(), input))
+    }
+    b.Reset()
+    if err = WriteColumns(&b, [][]string {{"a", "b"}, {"c"}}, nil, d); err != ErrColumnLength || b.Len() != 0 {
+        t.Fatal(fmt.Sprintf("WriteColumns returned %v and wrote %q for unequal columns", err, b.String()))
+    }
+}
+
+func TestTrimSpace(t *testing.T) {
+    for _, test := range []struct {
+        leading, trailing bool
+        cutset, expected  string
+    } {
+        {true, true, "", `[["a" "b\\ " "c" "d"]]`},
+        {true, false, "", `[["a \t" "b\\  " "c\t " "d  "]]`},
+        {false, true, "", `[[" \ta" " b\\ " "\t c" "  \"d\""]]`},
+        {true, true, " ", `[["\ta \t" "b\\ " "\t c\t" "d"]]`},
+    } {
+        reader := NewReader(strings.NewReader(" \ta \t: b\\\\\\  :\t c\t :  \"d\"  \n"))
+        reader.Quote = '"'
+        reader.TrimLeadingSpace = test.leading
+        reader.TrimTrailingSpace = test.trailing
+        reader.TrimCutset = test.cutset
+        records, err := reader.ReadAll()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if s := fmt.Sprintf("%q", records); s != test.expected {
+            t.Fatal(fmt.Sprintf("trimming (%v, %v, %q) read %s instead of %s", test.leading, test.trailing, test.cutset, s, test.expected))
+        }
+    }
+}
+
+func TestReadLineNumber(t *testing.T) {
+    reader := NewReader(strings.NewReader("a\n\nb:c\nd\\\ne\nf\n"))
+    record, err := reader.ReadLineNumber(3)
+    if err != nil || fmt.Sprintf("%q", record) != `["d\ne"]` {
+        t.Fatal(fmt.Sprintf("ReadLineNumber(3) returned %q, %v", record, err))
+    }
+    if _, err = reader.ReadLineNumber(2); err != ErrRecordNumber {
+        t.Fatal(fmt.Sprintf("ReadLineNumber(2) returned %v after record 3 instead of ErrRecordNumber", err))
+    }
+    if record, err = reader.ReadLineNumber(4); err != nil || fmt.Sprintf("%q", record) != `["f"]` {
+        t.Fatal(fmt.Sprintf("ReadLineNumber(4) returned %q, %v", record, err))
+    }
+    if _, err = NewReader(strings.NewReader("a\nb\n")).ReadLineNumber(3); err != ErrRecordNumber {
+        t.Fatal(fmt.Sprintf("ReadLineNumber(3) returned %v for two records instead of ErrRecordNumber", err))
+    }
+}
+
+func TestTransformStream(t *testing.T) {
+    explode := func(record []string) ([][]string, error) {
+        if len(record) != 2 {
+            return nil, ErrFieldCount
+        }
+        var records [][]string
+        for _, tag := range strings.Split(record[1], ",") {
+            if tag != "" {
+                records = append(records, []string {record[0], tag})
+            }
+        }
+        return records, nil
+    }
+    var b bytes.Buffer
+    err := TransformStream(NewWriter(&b), NewReader(strings.NewReader("a:x,y,z\nb:\nc:w\n")), explode)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != "a:x\na:y\na:z\nc:w\n" {
+        t.Fatal(fmt.Sprintf("TransformStream produced %q", b.String()))
+    }
+    b.Reset()
+    err = TransformStream(NewWriter(&b), NewReader(strings.NewReader("a:x\nb\nc:y\n")), explode)
+    if err != ErrFieldCount {
+        t.Fatal(fmt.Sprintf("TransformStream returned %v instead of the transform's error", err))
+    }
+}
+
+func TestRawFieldMarker(t *testing.T) {
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.RawFieldMarker = '='
+    writer.MarkRawFields = func(index int) bool {
+        return index == 1
+    }
+    records := [][]string {{"a", "\\x\\y \\\\z\" ", "=b"}, {"c\nd", "", "e"}}
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != "a:=\\x\\y \\\\z\" :\\=b\nc\\\nd:=:e\n" {
+        t.Fatal(fmt.Sprintf("Writer with raw fields produced %q", b.String()))
+    }
+    reader := NewReader(strings.NewReader(b.String()))
+    reader.RawFieldMarker = '='
+    reader.Quote = '"'
+    reader.TrimTrailingSpace = true
+    output, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("Reader with raw fields read %q instead of %q", output, records))
+    }
+    err = writer.Write([]string {"f", "g:h"})
+    if writeErr, ok := err.(*WriteError); !ok || writeErr.Err != ErrRawField || writeErr.Record != 3 || writeErr.Field != 2 {
+        t.Fatal(fmt.Sprintf("Write returned %v for a raw field containing a separator", err))
+    }
+}
+
+func TestContinueOnError(t *testing.T) {
+    records := [][]string {{"a", "b"}, {"c\x00"}, {"d"}, {"e", "f", "g"}, {"h"}}
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.RejectNUL = true
+    writer.SplitLimit = 2
+    err := writer.WriteAll(records)
+    writer.Flush()
+    if err == nil || b.String() != "a:b\n" {
+        t.Fatal(fmt.Sprintf("WriteAll returned %v and wrote %q without ContinueOnError", err, b.String()))
+    }
+
+    b.Reset()
+    writer = NewWriter(&b)
+    writer.RejectNUL = true
+    writer.SplitLimit = 2
+    writer.ContinueOnError = true
+    err = writer.WriteAll(records)
+    if b.String() != "a:b\nd\nh\n" {
+        t.Fatal(fmt.Sprintf("WriteAll with ContinueOnError wrote %q", b.String()))
+    }
+    var writeErr *WriteError
+    if !errors.As(err, &writeErr) || writeErr.Record != 2 || !errors.Is(err, ErrTooManyFields) {
+        t.Fatal(fmt.Sprintf("WriteAll with ContinueOnError returned %v", err))
+    }
+    if err = writer.WriteAll([][]string {{"i"}}); err != nil {
+        t.Fatal(fmt.Sprintf("WriteAll with ContinueOnError returned %v for a valid record", err))
+    }
+}
+
+func TestSeekRecordSplit(t *testing.T) {
+    reader := NewReader(strings.NewReader("a:b\nc:d\n"))
+    reader.Split = bufio.ScanLines
+    if record, err := reader.Read(); err != nil || fmt.Sprintf("%q", record) != `["a" "b"]` {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v", record, err))
+    }
+    if err := reader.SeekRecord(0); err != ErrUnsupportedFraming {
+        t.Fatal(fmt.Sprintf("SeekRecord returned %v instead of ErrUnsupportedFraming with Split", err))
+    }
+}
+
+func TestSniffQuoted(t *testing.T) {
+    sample := "name,note\n\"smith, j\",\"said \"\"hi, there\"\"\"\n\"doe, a\",C:\\x\n"
+    d, confidence := Sniff([]byte(sample))
+    if d.Separator != ',' || d.Quote != '"' || d.Escape != 0 || confidence < 0.9 {
+        t.Fatal(fmt.Sprintf("Sniff returned %+v with confidence %v for quoted CSV", d, confidence))
+    }
+    reader := NewReader(strings.NewReader(sample))
+    reader.SetDialect(d)
+    records, err := reader.ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := `[["name" "note"] ["smith, j" "said \"hi, there\""] ["doe, a" "C:\\x"]]`
+    if s := fmt.Sprintf("%q", records); s != expected {
+        t.Fatal(fmt.Sprintf("reading with the sniffed dialect returned %s instead of %s", s, expected))
+    }
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.SetDialect(d)
+    if err = writer.WriteAll(records); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != sample {
+        t.Fatal(fmt.Sprintf("writing with the sniffed dialect produced %q instead of %q", b.String(), sample))
+    }
+}
+
+func TestAutoFlushIntervalAfterExplicitFlush(t *testing.T) {
+    counter := flushCounter{}
+    clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+    writer := NewWriter(&counter)
+    writer.now = func() time.Time { return clock }
+    writer.AutoFlushInterval(time.Second)
+    clock = clock.Add(900 * time.Millisecond)
+    if err := writer.WriteAll([][]string {{"a"}}); err != nil || counter.writes != 1 {
+        t.Fatal(fmt.Sprintf("WriteAll returned %v after %v flushes", err, counter.writes))
+    }
+    clock = clock.Add(500 * time.Millisecond)
+    if err := writer.Write([]string {"b"}); err != nil || counter.writes != 1 {
+        t.Fatal(fmt.Sprintf("Write returned %v and flushed %v times within the interval after WriteAll", err, counter.writes))
+    }
+    clock = clock.Add(500 * time.Millisecond)
+    if err := writer.Write([]string {"c"}); err != nil || counter.writes != 2 {
+        t.Fatal(fmt.Sprintf("Write returned %v and flushed %v times after the interval", err, counter.writes))
+    }
+}
+
+func TestNextFieldRecovery(t *testing.T) {
+    reader := NewReader(strings.NewReader("abcdef:g\nh:i\n"))
+    reader.MaxFieldBytes = 3
+    _, _, err := reader.NextField()
+    if parseErr, ok := err.(*ParseError); !ok || parseErr.Err != ErrFieldTooLarge {
+        t.Fatal(fmt.Sprintf("NextField returned %v instead of ErrFieldTooLarge", err))
+    }
+    var fields []string
+    for {
+        field, end, err := reader.NextField()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        fields = append(fields, fmt.Sprintf("%s/%v", field, end))
+    }
+    if s := fmt.Sprintf("%q", fields); s != `["h/false" "i/true"]` {
+        t.Fatal(fmt.Sprintf("NextField returned %s after an error instead of the following record", s))
+    }
+}
+
+func TestLintBase64Fields(t *testing.T) {
+    reader := NewReader(strings.NewReader("!!!\n###\nYQ==\n"))
+    reader.Base64Fields = func(index int) bool {
+        return true
+    }
+    problems, err := reader.Lint()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(problems) != 2 || problems[0].Record != 1 || problems[1].Record != 2 {
+        t.Fatal(fmt.Sprintf("Lint reported %+v instead of problems in records 1 and 2", problems))
+    }
+}
+
+func TestLintRejectNUL(t *testing.T) {
+    reader := NewReader(strings.NewReader("a\x00\nb\x00\nc\n"))
+    reader.RejectNUL = true
+    problems, err := reader.Lint()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(problems) != 2 || problems[0].Err != ErrNUL || problems[1].Err != ErrNUL || problems[1].Record != 2 {
+        t.Fatal(fmt.Sprintf("Lint reported %+v instead of NUL bytes in records 1 and 2", problems))
+    }
+}
+
+func TestWriteWithSeparatorConflict(t *testing.T) {
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    if err := writer.WriteWithSeparator([]string {"a", "b\\c"}, '\\'); err != ErrSeparatorConflict {
+        t.Fatal(fmt.Sprintf("WriteWithSeparator returned %v instead of ErrSeparatorConflict", err))
+    }
+    if err := writer.WriteWithSeparator([]string {"a", "b"}, '\n'); err != ErrRecordSeparatorConflict {
+        t.Fatal(fmt.Sprintf("WriteWithSeparator returned %v instead of ErrRecordSeparatorConflict", err))
+    }
+    writer.Flush()
+    if b.Len() != 0 || writer.Separator != ':' {
+        t.Fatal(fmt.Sprintf("rejected WriteWithSeparator calls wrote %q and left separator %q", b.String(), writer.Separator))
+    }
+}
+
+func TestDialectComment(t *testing.T) {
+    reader := NewReader(strings.NewReader(""))
+    reader.Comment = '#'
+    d := reader.Dialect()
+    if fields, err := ParseRecord("#x:y\na:b\n", d); err != nil || fmt.Sprintf("%q", fields) != `["a" "b"]` {
+        t.Fatal(fmt.Sprintf("ParseRecord returned %q, %v with a comment dialect", fields, err))
+    }
+    var b bytes.Buffer
+    if _, err := Transcode(&b, strings.NewReader("# note\n#a:b\n\\#c:d\n"), d, d); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != "\\#c:d\n" {
+        t.Fatal(fmt.Sprintf("Transcode with a comment dialect produced %q", b.String()))
+    }
+    writer := NewWriter(nil)
+    writer.SetDialect(d)
+    if writer.Comment != '#' || writer.Dialect() != d {
+        t.Fatal(fmt.Sprintf("Writer dialect %+v doesn't match Reader dialect %+v", writer.Dialect(), d))
+    }
+}
+
+func TestDialectTrim(t *testing.T) {
+    reader := NewReader(strings.NewReader(""))
+    reader.TrimLeadingSpace = true
+    reader.TrimTrailingSpace = true
+    reader.TrimCutset = " "
+    fields, err := ParseRecord("  a\t : b  \n", reader.Dialect())
+    if err != nil || fmt.Sprintf("%q", fields) != `["a\t" "b"]` {
+        t.Fatal(fmt.Sprintf("ParseRecord returned %q, %v with a trimming dialect", fields, err))
+    }
+}
+
+type failingWriter struct {
+    err         error
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+    return 0, f.err
+}
+
+func TestContinueOnErrorRecordNumbers(t *testing.T) {
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.RejectNUL = true
+    writer.ContinueOnError = true
+    err := writer.WriteAll([][]string {{"a"}, {"x\x00"}, {"y\x00"}})
+    var numbers []int64
+    for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+        numbers = append(numbers, e.(*WriteError).Record)
+    }
+    if fmt.Sprint(numbers) != "[2 3]" || b.String() != "a\n" {
+        t.Fatal(fmt.Sprintf("WriteAll reported records %v and wrote %q", numbers, b.String()))
+    }
+
+    writer = NewWriter(&b)
+    writer.SplitLimit = 1
+    records := [][]string {{"a", "b"}, {"c"}}
+    if err = writer.WriteAll(records); err == ErrTooManyFields || !errors.Is(err, ErrTooManyFields) {
+        t.Fatal(fmt.Sprintf("WriteAll returned %v instead of a WriteError wrapping ErrTooManyFields", err))
+    }
+    if writeErr, ok := err.(*WriteError); !ok || writeErr.Record != 1 || writeErr.Field != 2 {
+        t.Fatal(fmt.Sprintf("WriteAll returned %v without ContinueOnError", err))
+    }
+
+    failure := errors.New("write failed")
+    writer = NewWriter(&failingWriter {failure})
+    if err = writer.WriteAll([][]string {{"a"}}); err != failure {
+        t.Fatal(fmt.Sprintf("WriteAll returned %v instead of the flush error", err))
+    }
+}
+
+func TestWriteWithoutEscape(t *testing.T) {
+    records := [][]string {{"a,", "b", "c"}, {"\"q", "x\ny"}, {"plain", "\x00"}}
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.Escape = 0
+    writer.Separator = ','
+    writer.Quote = '"'
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != "\"a,\",b,c\n\"\"\"q\",\"x\ny\"\nplain,\x00\n" {
+        t.Fatal(fmt.Sprintf("Writer with only quotes wrote %q", b.String()))
+    }
+    reader := NewReader(strings.NewReader(b.String()))
+    reader.SetDialect(writer.Dialect())
+    if output, err := reader.ReadAll(); err != nil || fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("Reader with only quotes read %q, %v", output, err))
+    }
+
+    b.Reset()
+    writer = NewWriter(&b)
+    writer.Escape = 0
+    writer.Separator = ','
+    records = [][]string {{"a", "b"}, {"c", "d e"}}
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal(err)
+    }
+    reader = NewReader(strings.NewReader(b.String()))
+    reader.SetDialect(writer.Dialect())
+    if output, err := reader.ReadAll(); err != nil || fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("Reader without escapes or quotes read %q, %v", output, err))
+    }
+    err := writer.Write([]string {"x", "a,", "b"})
+    if writeErr, ok := err.(*WriteError); !ok || writeErr.Err != ErrNoEscape || writeErr.Record != 3 || writeErr.Field != 2 {
+        t.Fatal(fmt.Sprintf("Write returned %v instead of ErrNoEscape in record 3 field 2", err))
+    }
+    writer.Flush()
+    if b.String() != "a,b\nc,d e\n" {
+        t.Fatal(fmt.Sprintf("Writer without escapes or quotes wrote %q", b.String()))
+    }
+    writer.ProtectRune('|')
+    if err = writer.Validate(); err != ErrNoEscape {
+        t.Fatal(fmt.Sprintf("Validate returned %v instead of ErrNoEscape for a protected rune", err))
+    }
+}
+
+func TestWriteCommentWithoutEscape(t *testing.T) {
+    records := [][]string {{"#a", "b"}, {"c", "#d"}}
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.Escape = 0
+    writer.Separator = ','
+    writer.Quote = '"'
+    writer.Comment = '#'
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != "\"#a\",b\nc,#d\n" {
+        t.Fatal(fmt.Sprintf("Writer with a comment character and quotes wrote %q", b.String()))
+    }
+    reader := NewReader(strings.NewReader(b.String()))
+    reader.SetDialect(writer.Dialect())
+    if output, err := reader.ReadAll(); err != nil || fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("Reader with a comment character and quotes read %q, %v", output, err))
+    }
+}
+
+func TestWriteLeadingQuote(t *testing.T) {
+    records := [][]string {{"\"a:b", "c"}, {"x", "y\""}}
+    var b bytes.Buffer
+    writer := NewWriter(&b)
+    writer.Quote = '"'
+    if err := writer.WriteAll(records); err != nil {
+        t.Fatal(err)
+    }
+    if b.String() != "\\\"a\\:b:c\nx:y\"\n" {
+        t.Fatal(fmt.Sprintf("Writer with quotes wrote %q", b.String()))
+    }
+    reader := NewReader(strings.NewReader(b.String()))
+    reader.Quote = '"'
+    if output, err := reader.ReadAll(); err != nil || fmt.Sprintf("%q", output) != fmt.Sprintf("%q", records) {
+        t.Fatal(fmt.Sprintf("Reader with quotes read %q, %v", output, err))
+    }
+}
+
+func TestNewReaderSeeker(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "records.dsv")
+    if err := os.WriteFile(path, []byte("a:b\nc:d\ne:f\n"), 0666); err != nil {
+        t.Fatal(err)
+    }
+    file, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    reader := NewReaderSeeker(file)
+    defer reader.Close()
+    reader.KeepRaw = true
+    reader.DebugContext = true
+    if _, err = reader.Read(); err != nil {
+        t.Fatal(err)
+    }
+    offset := reader.RecordOffset()
+    if _, err = reader.ReadAll(); err != nil {
+        t.Fatal(err)
+    }
+    if err = reader.SeekRecord(offset); err != nil {
+        t.Fatal(fmt.Sprintf("SeekRecord returned %v for a buffered file", err))
+    }
+    if string(reader.RawRecord()) != "" {
+        t.Fatal(fmt.Sprintf("RawRecord returned %q after seeking", reader.RawRecord()))
+    }
+    if len(reader.context) != 0 {
+        t.Fatal(fmt.Sprintf("debug context %q survived seeking", reader.context))
+    }
+    record, err := reader.Read()
+    if err != nil || fmt.Sprintf("%q", record) != `["a" "b"]` || string(reader.RawRecord()) != "a:b" {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v with raw record %q after seeking", record, err, reader.RawRecord()))
+    }
+    if record, err = reader.Read(); err != nil || fmt.Sprintf("%q", record) != `["c" "d"]` {
+        t.Fatal(fmt.Sprintf("Read returned %q, %v after seeking", record, err))
+    }
+}
+
+func TestResolveEscapeRawFields(t *testing.T) {
+    reader := NewReader(strings.NewReader("a\\,b,c\\|d:e\n"))
+    reader.KeepRawFields = true
+    reader.ResolveEscape = func(next rune) (rune, bool, bool) {
+        return -1, next == ',' || next == '|', next != '|'
+    }
+    record, err := reader.Read()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := fmt.Sprintf("%q", record); s != `["a" "b,c" "|d" "e"]` {
+        t.Fatal(fmt.Sprintf("Read returned %s", s))
+    }
+    if s := fmt.Sprintf("%q", reader.RawFields()); s != `["a" "b,c" "|d" "e"]` {
+        t.Fatal(fmt.Sprintf("RawFields returned %s for escape sequences that end fields", s))
+    }
+}
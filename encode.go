@@ -0,0 +1,206 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "io"
+    "reflect"
+    "strconv"
+    "time"
+)
+
+// An Encoder writes structs to a Writer as records.
+//
+// If Header is set, the first call to Encode writes a header naming the
+// columns of the struct's fields (see MarshalRecord) before the struct's
+// record.  The header is written with the Writer's WriteHeader method, so it
+// isn't repeated if the Writer has already written a header.
+type Encoder struct {
+    Header      bool    // write a header before the first record
+    writer      *Writer
+}
+
+// FieldMarshaler is implemented by types that can format themselves as DSV
+// fields.  MarshalDSVField returns the unescaped field; Writers escape it as
+// necessary.
+type FieldMarshaler interface {
+    MarshalDSVField() (string, error)
+}
+
+// An EncodeError describes a struct field that couldn't be formatted as a
+// DSV field.
+type EncodeError struct {
+    Field       string  // name of the struct field
+    Err         error   // reason the field couldn't be formatted
+}
+
+func (e *EncodeError) Error() string {
+    return "dsv: cannot encode field " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *EncodeError) Unwrap() error {
+    return e.Err
+}
+
+// NewEncoder returns a new Encoder that writes records to w.  Callers must
+// flush w after encoding the last struct.
+func NewEncoder(w *Writer) *Encoder {
+    return &Encoder {
+        writer: w,
+    }
+}
+
+// Encode writes the struct v (or the struct that v points to) to e's Writer
+// as a record.  See MarshalRecord.
+func (e *Encoder) Encode(v interface{}) error {
+    record, err := MarshalRecord(v)
+    if err != nil {
+        return err
+    }
+    if e.Header {
+        if err = e.WriteHeaderFromType(v); err != nil {
+            return err
+        }
+    }
+    return e.writer.Write(record)
+}
+
+// WriteHeaderFromType writes a header naming the columns of the struct type
+// of v (or of the struct type that v points to) to e's Writer, in the order
+// of the struct's fields and skipping fields tagged `dsv:"-"`, as Encode does
+// if e.Header is set.  Only v's type matters, so v may be a nil pointer.  As
+// with the Writer's WriteHeader method, nothing is written if the Writer has
+// already written a header.  WriteHeaderFromType returns ErrNotStruct if v
+// isn't a struct or a pointer to one.
+func (e *Encoder) WriteHeaderFromType(v interface{}) error {
+    t := reflect.TypeOf(v)
+    if t != nil && t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    if t == nil || t.Kind() != reflect.Struct {
+        return ErrNotStruct
+    }
+    return e.writer.WriteHeader(structHeader(t))
+}
+
+// MarshalRecord returns the fields of the struct v (or of the struct that v
+// points to) as a record.  The record's fields follow the order of the
+// struct's fields and obey the same `dsv` tags and types that Decoder
+// accepts, including the layout option for time.Time fields.  Fields of
+// types that implement FieldMarshaler (or whose pointer types do) are
+// formatted by their MarshalDSVField methods, and nil pointers are formatted
+// as empty fields.
+func MarshalRecord(v interface{}) (record []string, err error) {
+    s := reflect.ValueOf(v)
+    if s.Kind() == reflect.Ptr && !s.IsNil() {
+        s = s.Elem()
+    }
+    if s.Kind() != reflect.Struct {
+        return nil, ErrNotStruct
+    }
+    if !s.CanAddr() {
+        addressable := reflect.New(s.Type()).Elem()
+        addressable.Set(s)
+        s = addressable
+    }
+    for _, field := range structFields(s.Type()) {
+        str, err := encodeField(s.Field(field.index), field)
+        if err != nil {
+            return nil, &EncodeError {
+                Field: field.name,
+                Err:   err,
+            }
+        }
+        record = append(record, str)
+    }
+    return
+}
+
+// encodeField formats value, which is the value of field, as a DSV field.
+func encodeField(value reflect.Value, field structField) (string, error) {
+    if m, ok := value.Interface().(FieldMarshaler); ok {
+        if value.Kind() == reflect.Ptr && value.IsNil() {
+            return "", nil
+        }
+        return m.MarshalDSVField()
+    }
+    if value.CanAddr() {
+        if m, ok := value.Addr().Interface().(FieldMarshaler); ok {
+            return m.MarshalDSVField()
+        }
+    }
+    if value.Type() == timeType {
+        return value.Interface().(time.Time).Format(field.layout), nil
+    }
+    switch value.Kind() {
+        case reflect.Ptr:
+            if value.IsNil() {
+                return "", nil
+            }
+            return encodeField(value.Elem(), field)
+        case reflect.String:
+            return value.String(), nil
+        case reflect.Bool:
+            return strconv.FormatBool(value.Bool()), nil
+        case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+            return strconv.FormatInt(value.Int(), 10), nil
+        case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+            return strconv.FormatUint(value.Uint(), 10), nil
+        case reflect.Float32, reflect.Float64:
+            return strconv.FormatFloat(value.Float(), 'g', -1, value.Type().Bits()), nil
+    }
+    return "", ErrUnsupportedType
+}
+
+// MarshalAll writes the slice of structs (or of pointers to structs) v to w
+// using the settings in d: a header naming the structs' columns followed by
+// one record per struct, as written by an Encoder with Header set.  The header
+// is written even if v is empty.  MarshalAll flushes its output and returns
+// ErrNotStruct if v isn't such a slice.
+func MarshalAll(w io.Writer, v interface{}, d Dialect) error {
+    slice := reflect.ValueOf(v)
+    if slice.Kind() != reflect.Slice {
+        return ErrNotStruct
+    }
+    t := slice.Type().Elem()
+    if t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    if t.Kind() != reflect.Struct {
+        return ErrNotStruct
+    }
+    writer := NewWriter(w)
+    writer.SetDialect(d)
+    if err := writer.WriteHeader(structHeader(t)); err != nil {
+        return err
+    }
+    for n := 0; n < slice.Len(); n++ {
+        record, err := MarshalRecord(slice.Index(n).Interface())
+        if err != nil {
+            return err
+        }
+        if err = writer.Write(record); err != nil {
+            return err
+        }
+    }
+    writer.Flush()
+    return writer.Error()
+}
+
+// structHeader returns the names of the columns of the struct type t's
+// fields.
+func structHeader(t reflect.Type) (header []string) {
+    for _, field := range structFields(t) {
+        header = append(header, field.column)
+    }
+    return
+}
@@ -0,0 +1,177 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+)
+
+type priority int
+
+func (p priority) MarshalDSVField() (string, error) {
+    switch p {
+        case 0:
+            return "low", nil
+        case 1:
+            return "high", nil
+    }
+    return "", errors.New("unknown priority")
+}
+
+func TestMarshalFieldMarshaler(t *testing.T) {
+    name := "Ada"
+    value := struct {
+        Task        string      `dsv:"task"`
+        Priority    priority    `dsv:"priority"`
+        Owner       *string     `dsv:"owner"`
+        Reviewer    *string     `dsv:"reviewer"`
+        Hours       float64     `dsv:"hours"`
+        Ignored     int         `dsv:"-"`
+    } {"write docs", 1, &name, nil, 2.5, 7}
+    record, err := MarshalRecord(&value)
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while marshaling struct: %v", err))
+    }
+    expectedRecord := []string {"write docs", "high", "Ada", "", "2.5"}
+    if fmt.Sprintf("%q", record) != fmt.Sprintf("%q", expectedRecord) {
+        t.Fatal(fmt.Sprintf("marshaled record %q doesn't match expected record %q",
+            record, expectedRecord))
+    }
+
+    value.Priority = 5
+    _, err = MarshalRecord(value)
+    encodeErr, ok := err.(*EncodeError)
+    if !ok || encodeErr.Field != "Priority" {
+        t.Fatal(fmt.Sprintf("MarshalRecord returned %v instead of an EncodeError for Priority", err))
+    }
+    if _, err = MarshalRecord(5); err != ErrNotStruct {
+        t.Fatal(fmt.Sprintf("MarshalRecord returned %v instead of ErrNotStruct", err))
+    }
+}
+
+func TestMarshalTime(t *testing.T) {
+    due := time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC)
+    record, err := MarshalRecord(struct {
+        Created time.Time   `dsv:"created"`
+        Due     *time.Time  `dsv:"due,layout=Jan 2, 2006"`
+    } {time.Date(2015, 4, 29, 10, 30, 0, 0, time.UTC), &due})
+    if err != nil {
+        t.Fatal(fmt.Sprintf("error while marshaling struct: %v", err))
+    }
+    expectedRecord := []string {"2015-04-29T10:30:00Z", "May 1, 2015"}
+    if fmt.Sprintf("%q", record) != fmt.Sprintf("%q", expectedRecord) {
+        t.Fatal(fmt.Sprintf("marshaled record %q doesn't match expected record %q",
+            record, expectedRecord))
+    }
+}
+
+type encodedRecord struct {
+    Name        string      `dsv:"name"`
+    Nickname    *string     `dsv:"nickname"`
+    Count       int         `dsv:"count"`
+    Ratio       float64
+}
+
+func TestEncoder(t *testing.T) {
+    nickname := "Amazing: Grace"
+    records := []encodedRecord {
+        {"Ada", nil, 1, 0.5},
+        {"Grace", &nickname, 2, 1.25},
+    }
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    encoder := NewEncoder(writer)
+    encoder.Header = true
+    for n := range records {
+        if err := encoder.Encode(&records[n]); err != nil {
+            t.Fatal(fmt.Sprintf("error while encoding struct: %v", err))
+        }
+    }
+    writer.Flush()
+    expectedOutput := "name:nickname:count:Ratio\nAda::1:0.5\nGrace:Amazing\\: Grace:2:1.25\n"
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("encoded DSV %q doesn't match expected DSV %q", buffer.String(), expectedOutput))
+    }
+
+    decoder := NewDecoder(NewReader(&buffer))
+    for n, expected := range records {
+        var record encodedRecord
+        if err := decoder.Decode(&record); err != nil {
+            t.Fatal(fmt.Sprintf("error while decoding encoded struct: %v", err))
+        }
+        if record.Name != expected.Name || record.Count != expected.Count || record.Ratio != expected.Ratio {
+            t.Fatal(fmt.Sprintf("decoded struct %v doesn't match encoded struct %v", n, expected))
+        }
+        // Nil pointers are encoded as empty fields.
+        expectedNickname := ""
+        if expected.Nickname != nil {
+            expectedNickname = *expected.Nickname
+        }
+        if record.Nickname == nil || *record.Nickname != expectedNickname {
+            t.Fatal(fmt.Sprintf("decoded nickname of struct %v doesn't match encoded nickname", n))
+        }
+    }
+}
+
+func TestMarshalAll(t *testing.T) {
+    dialect := Dialect {Escape: '\\', Separator: ':', RecordSeparator: '\n'}
+    nickname, ages := "Countess", []int {36, 41}
+    records := []nullableRecord {
+        {Name: "Ada", Nickname: &nickname, Age: &ages[0]},
+        {Name: "Alan", Age: &ages[1]},
+    }
+    var buffer bytes.Buffer
+    if err := MarshalAll(&buffer, records, dialect); err != nil {
+        t.Fatal(fmt.Sprintf("error while marshaling valid structs: %v", err))
+    }
+    var decoded []nullableRecord
+    if err := UnmarshalAll(bytes.NewBufferString(buffer.String()), &decoded, dialect); err != nil {
+        t.Fatal(fmt.Sprintf("error while unmarshaling %q: %v", buffer.String(), err))
+    }
+    if len(decoded) != 2 || decoded[0].Name != "Ada" || *decoded[0].Nickname != "Countess" ||
+            *decoded[0].Age != 36 || decoded[1].Name != "Alan" || *decoded[1].Nickname != "" || *decoded[1].Age != 41 {
+        t.Fatal(fmt.Sprintf("round trip through %q produced %+v", buffer.String(), decoded))
+    }
+    if err := MarshalAll(&buffer, records[0], dialect); err != ErrNotStruct {
+        t.Fatal(fmt.Sprintf("MarshalAll returned %v instead of ErrNotStruct", err))
+    }
+}
+
+func TestWriteHeaderFromType(t *testing.T) {
+    buffer := bytes.Buffer{}
+    writer := NewWriter(&buffer)
+    encoder := NewEncoder(writer)
+    if err := encoder.WriteHeaderFromType((*struct {
+        ID          int         `dsv:"id"`
+        Secret      string      `dsv:"-"`
+        Due         time.Time   `dsv:"due,layout=2006-01-02"`
+        internal    int
+        Note        string
+    })(nil)); err != nil {
+        t.Fatal(fmt.Sprintf("error while writing header: %v", err))
+    }
+    if err := encoder.WriteHeaderFromType(encodedRecord{}); err != nil {
+        t.Fatal(fmt.Sprintf("error while writing header: %v", err))
+    }
+    writer.Flush()
+    expectedOutput := "id:due:Note\n"
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("written header %q doesn't match expected header %q", buffer.String(), expectedOutput))
+    }
+    if err := encoder.WriteHeaderFromType([]string {"id"}); err != ErrNotStruct {
+        t.Fatal(fmt.Sprintf("WriteHeaderFromType returned %v instead of ErrNotStruct", err))
+    }
+}
@@ -0,0 +1,73 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "io"
+)
+
+// A PartitionWriter routes records to separate Writers according to a key
+// computed from each record, such as one output file per value of the first
+// field.  Writers are created on demand for new keys.
+type PartitionWriter struct {
+    Dialect     Dialect    // settings of the created Writers
+    key         func([]string) string
+    create      func(string) (io.Writer, error)
+    writers     map[string]*Writer
+    keys        []string
+}
+
+// NewPartitionWriter returns a new PartitionWriter that computes each
+// record's key with key and calls create to obtain the io.Writer for each new
+// key.  The PartitionWriter's Writers use the same escape and separator
+// characters as those returned by NewWriter unless its Dialect field is
+// modified before the first call to Write.
+func NewPartitionWriter(key func(record []string) string, create func(key string) (io.Writer, error)) *PartitionWriter {
+    return &PartitionWriter {
+        Dialect: NewWriter(nil).Dialect(),
+        key:     key,
+        create:  create,
+        writers: make(map[string]*Writer),
+    }
+}
+
+// Write writes record to the Writer for its key, creating the Writer first
+// if necessary.  It returns any error returned by create or by the Writer.
+// Records are buffered until Close is called.
+func (p *PartitionWriter) Write(record []string) error {
+    key := p.key(record)
+    writer, ok := p.writers[key]
+    if !ok {
+        w, err := p.create(key)
+        if err != nil {
+            return err
+        }
+        writer = NewWriter(w)
+        writer.SetDialect(p.Dialect)
+        p.writers[key] = writer
+        p.keys = append(p.keys, key)
+    }
+    return writer.Write(record)
+}
+
+// Close closes every Writer that p created, in the order in which their keys
+// first appeared, flushing them and closing their io.Writers if they
+// implement io.Closer (see Writer.Close).  It closes all of them even if some
+// fail and returns the first error encountered.
+func (p *PartitionWriter) Close() (err error) {
+    for _, key := range p.keys {
+        if closeErr := p.writers[key].Close(); err == nil {
+            err = closeErr
+        }
+    }
+    return
+}
@@ -0,0 +1,62 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "testing"
+)
+
+func TestPartitionWriter(t *testing.T) {
+    buffers := make(map[string]*bytes.Buffer)
+    var created []string
+    writer := NewPartitionWriter(func(record []string) string {
+        return record[0]
+    }, func(key string) (io.Writer, error) {
+        if key == "bad" {
+            return nil, errors.New("no writer for key")
+        }
+        created = append(created, key)
+        buffers[key] = &bytes.Buffer{}
+        return buffers[key], nil
+    })
+    writer.Dialect.Separator = '|'
+    records := [][]string {
+        {"a", "1"},
+        {"b", "2|x"},
+        {"a", "3"},
+    }
+    for _, record := range records {
+        if err := writer.Write(record); err != nil {
+            t.Fatal(fmt.Sprintf("error while writing a partitioned record: %v", err))
+        }
+    }
+    if err := writer.Write([]string {"bad"}); err == nil {
+        t.Fatal("Write didn't return the error from creating a Writer")
+    }
+    if buffers["a"].Len() != 0 {
+        t.Fatal("PartitionWriter flushed records before Close")
+    }
+    if err := writer.Close(); err != nil {
+        t.Fatal(fmt.Sprintf("error while closing a PartitionWriter: %v", err))
+    }
+    if fmt.Sprint(created) != "[a b]" {
+        t.Fatal(fmt.Sprintf("PartitionWriter created Writers for keys %v", created))
+    }
+    if buffers["a"].String() != "a|1\na|3\n" || buffers["b"].String() != "b|2\\|x\n" {
+        t.Fatal(fmt.Sprintf("partitions %q and %q don't hold the expected records",
+            buffers["a"].String(), buffers["b"].String()))
+    }
+}
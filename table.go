@@ -0,0 +1,145 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "bufio"
+    "io"
+    "strings"
+    "unicode/utf8"
+)
+
+// A TableRenderer renders records as a column-aligned, space-padded text
+// table for people to read rather than as DSV.
+//
+// Columns are as wide as their widest lines, counted in runes, and are
+// separated by two spaces.  Fields containing newlines occupy several lines.
+// If MaxWidth is positive, lines longer than MaxWidth runes are truncated on
+// the right, or wrapped onto further lines if Wrap is set.
+//
+// Each column's lines (including the header's) are left-justified unless
+// Justify has an element for the column that says otherwise, so
+// Justify: []Alignment {AlignRight} right-justifies a numeric first column
+// while leaving the others left-justified.
+type TableRenderer struct {
+    MaxWidth    int            // maximum column width in runes (0 for none)
+    Wrap        bool           // wrap long lines instead of truncating them
+    Justify     []Alignment    // justification of each column (left by default)
+}
+
+// An Alignment determines how a TableRenderer justifies a column's lines.
+type Alignment int
+
+const (
+    // AlignLeft pads lines on the right.
+    AlignLeft Alignment = iota
+
+    // AlignRight pads lines on the left.
+    AlignRight
+)
+
+// RenderTable renders records to w with a TableRenderer's default settings.
+// See TableRenderer.Render.
+func RenderTable(w io.Writer, records [][]string, header []string) error {
+    return TableRenderer {}.Render(w, records, header)
+}
+
+// Render writes records to w as a table.  If header isn't nil, it is
+// rendered first and underlined with hyphens.  Records may have different
+// numbers of fields.  Trailing spaces are omitted from each line.
+func (t TableRenderer) Render(w io.Writer, records [][]string, header []string) error {
+    rows := records
+    if header != nil {
+        rows = append([][]string {header}, records...)
+    }
+
+    // Split the fields into lines and measure the columns.
+    cells := make([][][]string, len(rows))
+    var widths []int
+    for i, row := range rows {
+        cells[i] = make([][]string, len(row))
+        for j, field := range row {
+            cells[i][j] = t.lines(field)
+            if j == len(widths) {
+                widths = append(widths, 0)
+            }
+            for _, line := range cells[i][j] {
+                if n := utf8.RuneCountInString(line); n > widths[j] {
+                    widths[j] = n
+                }
+            }
+        }
+    }
+
+    writer := bufio.NewWriter(w)
+    for i, row := range cells {
+        height := 1
+        for _, lines := range row {
+            if len(lines) > height {
+                height = len(lines)
+            }
+        }
+        for n := 0; n < height; n++ {
+            var line strings.Builder
+            for j, lines := range row {
+                var s string
+                if n < len(lines) {
+                    s = lines[n]
+                }
+                if j > 0 {
+                    line.WriteString("  ")
+                }
+                padding := strings.Repeat(" ", widths[j] - utf8.RuneCountInString(s))
+                if j < len(t.Justify) && t.Justify[j] == AlignRight {
+                    line.WriteString(padding)
+                    line.WriteString(s)
+                } else {
+                    line.WriteString(s)
+                    line.WriteString(padding)
+                }
+            }
+            writer.WriteString(strings.TrimRight(line.String(), " "))
+            writer.WriteByte('\n')
+        }
+        if i == 0 && header != nil {
+            var line strings.Builder
+            for j, width := range widths {
+                if j > 0 {
+                    line.WriteString("  ")
+                }
+                line.WriteString(strings.Repeat("-", width))
+            }
+            writer.WriteString(line.String())
+            writer.WriteByte('\n')
+        }
+    }
+    return writer.Flush()
+}
+
+// lines splits field into the lines that t renders for it.
+func (t TableRenderer) lines(field string) (lines []string) {
+    for _, line := range strings.Split(field, "\n") {
+        runes := []rune(line)
+        for t.MaxWidth > 0 && len(runes) > t.MaxWidth {
+            lines = append(lines, string(runes[:t.MaxWidth]))
+            if !t.Wrap {
+                runes = nil
+                break
+            }
+            runes = runes[t.MaxWidth:]
+        }
+        if runes != nil {
+            lines = append(lines, string(runes))
+        }
+    }
+    return
+}
@@ -0,0 +1,94 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "bytes"
+    "fmt"
+    "testing"
+)
+
+func TestRenderTable(t *testing.T) {
+    records := [][]string {
+        {"1", "alice", "likes go"},
+        {"22", "b\u00f6b"},
+        {"333", "carol", "two\nlines"},
+    }
+    header := []string {"id", "name", "note"}
+    expectedOutput := "" +
+        "id   name   note\n" +
+        "---  -----  --------\n" +
+        "1    alice  likes go\n" +
+        "22   b\u00f6b\n" +
+        "333  carol  two\n" +
+        "            lines\n"
+    buffer := bytes.Buffer{}
+    if err := RenderTable(&buffer, records, header); err != nil {
+        t.Fatal("error while rendering a table")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("rendered table %q doesn't match expected table %q", buffer.String(), expectedOutput))
+    }
+
+    renderer := TableRenderer {MaxWidth: 4}
+    expectedOutput = "" +
+        "1    alic  like\n" +
+        "22   b\u00f6b\n" +
+        "333  caro  two\n" +
+        "           line\n"
+    buffer.Reset()
+    if err := renderer.Render(&buffer, records, nil); err != nil {
+        t.Fatal("error while rendering a truncated table")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("truncated table %q doesn't match expected table %q", buffer.String(), expectedOutput))
+    }
+
+    renderer.Wrap = true
+    expectedOutput = "" +
+        "1    alic  like\n" +
+        "     e     s go\n" +
+        "22   b\u00f6b\n" +
+        "333  caro  two\n" +
+        "     l     line\n" +
+        "           s\n"
+    buffer.Reset()
+    if err := renderer.Render(&buffer, records, nil); err != nil {
+        t.Fatal("error while rendering a wrapped table")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("wrapped table %q doesn't match expected table %q", buffer.String(), expectedOutput))
+    }
+}
+
+func TestRenderTableJustify(t *testing.T) {
+    records := [][]string {
+        {"alice", "1.5", "x"},
+        {"bob", "22"},
+        {"carol", "333.25", "y"},
+    }
+    header := []string {"name", "amount", "note"}
+    expectedOutput := "" +
+        "name   amount  note\n" +
+        "-----  ------  ----\n" +
+        "alice     1.5  x\n" +
+        "bob        22\n" +
+        "carol  333.25  y\n"
+    buffer := bytes.Buffer{}
+    renderer := TableRenderer {Justify: []Alignment {AlignLeft, AlignRight}}
+    if err := renderer.Render(&buffer, records, header); err != nil {
+        t.Fatal("error while rendering a justified table")
+    }
+    if buffer.String() != expectedOutput {
+        t.Fatal(fmt.Sprintf("justified table %q doesn't match expected table %q", buffer.String(), expectedOutput))
+    }
+}
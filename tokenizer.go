@@ -0,0 +1,106 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "io"
+)
+
+// A TokenKind identifies the kind of a Token.
+type TokenKind int
+
+const (
+    // TokenField is a decoded field.
+    TokenField TokenKind = iota
+
+    // TokenRecordEnd follows the last field of each record.
+    TokenRecordEnd
+
+    // TokenEOF marks the end of the input.
+    TokenEOF
+)
+
+// A Token is one element of the stream of fields and record boundaries that
+// a Tokenizer produces.
+type Token struct {
+    Kind        TokenKind    // kind of token
+    Value       string       // the decoded field if Kind is TokenField
+}
+
+// A Tokenizer splits a Reader's input into Tokens: a TokenField for each
+// field, a TokenRecordEnd after the last field of each record, and a final
+// TokenEOF.  It exposes the field parser beneath Read so that callers can
+// assemble records themselves, such as by streaming huge records field by
+// field.  Fields are parsed with the Reader's settings as Read parses them,
+// but trailing empty fields aren't trimmed and record-level settings such as
+// FieldsPerRecord and Base64Fields don't apply.  Tokenizers don't support
+// LengthPrefixed or Split.
+type Tokenizer struct {
+    reader      *Reader
+    recordEnd   bool
+}
+
+// NewTokenizer returns a new Tokenizer that reads from r.  Calls to the
+// Tokenizer's Next method and r's Read method may be mixed only after
+// TokenRecordEnd.
+func NewTokenizer(r *Reader) *Tokenizer {
+    return &Tokenizer {reader: r}
+}
+
+// Next returns the next Token.  If an error occurs within a field, the
+// returned TokenField holds the part of the field read before it.  After a
+// *ParseError, Next discards the rest of the record, up to its next unescaped
+// record separator, so that the next call begins the following record rather
+// than returning what remains of the broken one.  Next returns
+// ErrUnsupportedFraming if the Reader's LengthPrefixed or Split is set.
+func (t *Tokenizer) Next() (token Token, err error) {
+    r := t.reader
+    if err = r.Validate(); err != nil {
+        return
+    }
+    if r.LengthPrefixed || r.Split != nil {
+        return token, ErrUnsupportedFraming
+    }
+    if r.field.Cap() < r.InitialFieldCap {
+        r.field.Grow(r.InitialFieldCap)
+    }
+    starting := !r.inRecord && !t.recordEnd
+    token, err = t.next()
+    if starting && r.OnBlankLines != nil && r.blankLines > 0 {
+        r.OnBlankLines(r.blankLines)
+    }
+    if err != nil {
+        r.inRecord = false
+        if _, ok := err.(*ParseError); ok {
+            if skipErr := r.skipRecord(false); skipErr != nil {
+                return token, skipErr
+            }
+        }
+    }
+    return
+}
+
+// next returns the next Token for Next and Read without recovering from
+// errors.  If an error occurs, the Reader's inRecord reports whether it
+// occurred within a record, and the caller must clear it.
+func (t *Tokenizer) next() (Token, error) {
+    if t.recordEnd {
+        t.recordEnd = false
+        return Token {Kind: TokenRecordEnd}, nil
+    }
+    field, end, err := t.reader.nextField()
+    if err == io.EOF && !t.reader.inRecord {
+        return Token {Kind: TokenEOF}, nil
+    }
+    t.recordEnd = end && err == nil
+    return Token {Kind: TokenField, Value: field}, err
+}
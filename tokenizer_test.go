@@ -0,0 +1,80 @@
+// dsv: A Go Package for DSV Files
+// Written in 2015 by Jordan Vaughan
+
+// To the extent possible under law, the author(s) have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+
+// You should have received a copy of the CC0 Public Domain Dedication along
+// with this software. If not, see
+// <http://creativecommons.org/publicdomain/zero/1.0/>.
+
+package dsv
+
+import (
+    "bufio"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestTokenizer(t *testing.T) {
+    input := "a:b\\:c\n\nd\\\ne:\nf"
+    tokenizer := NewTokenizer(NewReader(strings.NewReader(input)))
+    var records [][]string
+    var record []string
+    for {
+        token, err := tokenizer.Next()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if token.Kind == TokenEOF {
+            break
+        }
+        if token.Kind == TokenRecordEnd {
+            records = append(records, record)
+            record = nil
+            continue
+        }
+        record = append(record, token.Value)
+    }
+    if record != nil {
+        t.Fatal(fmt.Sprintf("tokenizer ended without ending record %q", record))
+    }
+    if s := fmt.Sprintf("%q", records); s != `[["a" "b:c"] ["d\ne" ""] ["f"]]` {
+        t.Fatal(fmt.Sprintf("tokens reconstructed records %s", s))
+    }
+    if token, err := tokenizer.Next(); token.Kind != TokenEOF || err != nil {
+        t.Fatal(fmt.Sprintf("Next returned %+v, %v after the end of input", token, err))
+    }
+
+    reader := NewReader(strings.NewReader("a\nb:c\n"))
+    reader.Split = bufio.ScanLines
+    if _, err := NewTokenizer(reader).Next(); err != ErrUnsupportedFraming {
+        t.Fatal(fmt.Sprintf("Next returned %v instead of ErrUnsupportedFraming with Split", err))
+    }
+}
+
+func TestTokenizerRecovery(t *testing.T) {
+    reader := NewReader(strings.NewReader("abcdef:g\nh\n"))
+    reader.MaxFieldBytes = 3
+    tokenizer := NewTokenizer(reader)
+    if _, err := tokenizer.Next(); err == nil {
+        t.Fatal("Next didn't reject an oversized field")
+    }
+    var tokens []Token
+    for {
+        token, err := tokenizer.Next()
+        if err != nil {
+            t.Fatal(err)
+        }
+        tokens = append(tokens, token)
+        if token.Kind == TokenEOF {
+            break
+        }
+    }
+    expected := []Token {{TokenField, "h"}, {Kind: TokenRecordEnd}, {Kind: TokenEOF}}
+    if s := fmt.Sprintf("%v", tokens); s != fmt.Sprintf("%v", expected) {
+        t.Fatal(fmt.Sprintf("Next returned %s after an oversized field", s))
+    }
+}